@@ -1,15 +1,42 @@
 package main
 
 import (
+	"context"
 	"echo-server/internal/server"
+	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
-	server := server.NewServer()
+	server, closeServer := server.NewServer()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+		<-quit
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			fmt.Printf("cannot shut down server: %s\n", err)
+		}
+	}()
 
 	err := server.ListenAndServe()
-	if err != nil {
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		panic(fmt.Sprintf("cannot start server: %s", err))
 	}
+
+	// ListenAndServe returns as soon as Shutdown starts, wait for in-flight
+	// requests to drain before stopping the auth service.
+	<-done
+	closeServer()
 }
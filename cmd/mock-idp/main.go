@@ -0,0 +1,56 @@
+package main
+
+import (
+	"echo-server/internal/mockidp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9000", "address to listen on")
+	issuer := flag.String("issuer", "", "public URL of the provider (default http://<addr>)")
+	clientId := flag.String("client-id", "mock-client", "client_id the app signs in with")
+	clientSecret := flag.String("client-secret", "mock-secret", "client_secret the app signs in with")
+	usersFile := flag.String("users", "", "JSON file with the test users, [{\"sub\": \"...\", \"claims\": {...}}]")
+	flag.Parse()
+
+	if *issuer == "" {
+		*issuer = "http://" + *addr
+	}
+
+	var users []mockidp.User
+	if *usersFile != "" {
+		data, err := os.ReadFile(*usersFile)
+		if err != nil {
+			log.Fatalf("cannot read users: %s", err)
+		}
+		if err := json.Unmarshal(data, &users); err != nil {
+			log.Fatalf("cannot parse users: %s", err)
+		}
+	}
+
+	idp, err := mockidp.New(mockidp.Options{
+		Issuer:       *issuer,
+		ClientId:     *clientId,
+		ClientSecret: *clientSecret,
+		Users:        users,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("mock identity provider listening on %s, issuer %s\n", *addr, idp.Issuer())
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           idp,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if err := server.ListenAndServe(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	// ErrAccountNotLinked is returned when a provider account shares its email
+	// with an existing user but may not be linked to it automatically.
+	ErrAccountNotLinked = errors.New("account not linked")
+	// ErrAccountInUse is returned when linking a provider account that
+	// already belongs to another user.
+	ErrAccountInUse = errors.New("account is linked to another user")
+	// ErrProviderLinked is returned when linking a second account of a
+	// provider to a user, as accounts are unlinked by provider.
+	ErrProviderLinked = errors.New("an account of this provider is already linked")
+	// ErrLastAccount is returned when unlinking the only remaining way a user can sign in.
+	ErrLastAccount = errors.New("cannot remove the last sign-in method")
+)
+
+// AccountData is the public view of a linked account, without its tokens.
+type AccountData struct {
+	Id                string `json:"id"`
+	Type              string `json:"type"`
+	Provider          string `json:"provider"`
+	ProviderAccountId string `json:"providerAccountId"`
+}
+
+// Link starts the sign in flow of a provider and attaches the resulting
+// account to the signed in user instead of signing in. The callback needs
+// the session cookie, so providers that post it cross-site, such as Apple,
+// cannot be linked.
+func (s *Service) Link(c echo.Context) error {
+	_, user, err := s.resolveSession(c)
+	if err != nil {
+		return s.unauthorized(c)
+	}
+
+	provider, ok := (*s.providers)[c.Param("provider")]
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "provider not found",
+		})
+	}
+
+	return s.redirectToProvider(c, provider, user.Id)
+}
+
+// Accounts lists the provider accounts linked to the signed in user.
+func (s *Service) Accounts(c echo.Context) error {
+	_, user, err := s.resolveSession(c)
+	if err != nil {
+		return s.unauthorized(c)
+	}
+
+	accounts, err := (*s.adapter).GetAccounts(user.Id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	data := make([]AccountData, 0, len(accounts))
+	for _, acc := range accounts {
+		data = append(data, AccountData{
+			Id:                acc.Id,
+			Type:              acc.Type,
+			Provider:          acc.Provider,
+			ProviderAccountId: acc.ProviderAccountId,
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"accounts": data,
+	})
+}
+
+// Unlink removes the account of a provider from the signed in user and
+// revokes its tokens at the provider.
+func (s *Service) Unlink(c echo.Context) error {
+	_, user, err := s.resolveSession(c)
+	if err != nil {
+		return s.unauthorized(c)
+	}
+
+	accounts, err := (*s.adapter).GetAccounts(user.Id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	// DeleteAccount removes every account of the provider, the user must
+	// keep one of another provider to sign in with.
+	providerId := c.Param("provider")
+	var unlinked []Account
+	remaining := 0
+	for _, acc := range accounts {
+		if acc.Provider == providerId {
+			unlinked = append(unlinked, acc)
+		} else {
+			remaining++
+		}
+	}
+
+	if len(unlinked) == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": ErrAccountNotFound.Error(),
+		})
+	}
+	if remaining == 0 {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": ErrLastAccount.Error(),
+		})
+	}
+
+	// Deleting the accounts wipes their tokens, revoke them first.
+	for _, acc := range unlinked {
+		s.revokeTokens(c.Request().Context(), acc)
+	}
+	if err := (*s.adapter).DeleteAccount(user.Id, providerId); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// linkAccount attaches account to the user with userId.
+func (s *Service) linkAccount(c echo.Context, userId string, account Account) (User, error) {
+	user, err := (*s.adapter).GetUserById(userId)
+	if err != nil {
+		return User{}, err
+	}
+
+	if owner, err := (*s.adapter).GetUserByAccount(account.Provider, account.ProviderAccountId); err == nil {
+		if owner.Id != user.Id {
+			return User{}, ErrAccountInUse
+		}
+		return user, nil
+	}
+
+	if linked, err := s.hasProviderAccount(user.Id, account.Provider); err != nil {
+		return User{}, err
+	} else if linked {
+		return User{}, ErrProviderLinked
+	}
+
+	account.UserId = user.Id
+	if _, err := (*s.adapter).LinkAccount(account); err != nil {
+		return User{}, err
+	}
+
+	s.publish(c, Event{Type: EventLinkAccount, User: user, Account: &account, Provider: account.Provider})
+	return user, nil
+}
+
+// signInUser returns the user behind a provider account. Unknown accounts
+// are linked to the user with the same email when the provider allows it
+// and both have verified it, and get a new user otherwise. An unverified
+// email of the existing user may have been claimed by someone else, who
+// would then own the account signing in.
+func (s *Service) signInUser(c echo.Context, provider Provider, user User, account Account, profile Profile) (User, bool, error) {
+	if u, err := (*s.adapter).GetUserByAccount(account.Provider, account.ProviderAccountId); err == nil {
+		if err := s.storeTokens(u.Id, account); err != nil {
+			return User{}, false, err
+		}
+		return u, false, nil
+	}
+
+	if user.Email != "" {
+		if existing, err := (*s.adapter).GetUserByEmail(user.Email); err == nil {
+			if !provider.AllowsEmailLinking() || !profile.EmailVerified || existing.EmailVerified == nil {
+				return User{}, false, ErrAccountNotLinked
+			}
+			if linked, err := s.hasProviderAccount(existing.Id, account.Provider); err != nil {
+				return User{}, false, err
+			} else if linked {
+				return User{}, false, ErrAccountNotLinked
+			}
+
+			account.UserId = existing.Id
+			if _, err := (*s.adapter).LinkAccount(account); err != nil {
+				return User{}, false, err
+			}
+
+			s.publish(c, Event{Type: EventLinkAccount, User: existing, Account: &account, Provider: account.Provider})
+			return existing, false, nil
+		}
+	}
+
+	u, err := (*s.adapter).CreateUser(user, account)
+	if err != nil {
+		return User{}, false, err
+	}
+	return u, true, nil
+}
+
+// hasProviderAccount reports whether the user has an account of provider.
+func (s *Service) hasProviderAccount(userId, provider string) (bool, error) {
+	accounts, err := (*s.adapter).GetAccounts(userId)
+	if err != nil {
+		return false, err
+	}
+	for _, acc := range accounts {
+		if acc.Provider == provider {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// emailVerifiedAt returns the timestamp stored in User.EmailVerified for a
+// profile whose email the provider has verified.
+func emailVerifiedAt(profile Profile) *string {
+	if !profile.EmailVerified {
+		return nil
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	return &now
+}
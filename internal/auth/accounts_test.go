@@ -0,0 +1,254 @@
+package auth_test
+
+import (
+	"echo-server/internal/auth"
+	"echo-server/internal/auth/adapters"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestLink(t *testing.T) {
+	primary := &fakeProvider{id: "fake", profile: auth.Profile{Id: "link-1", Email: "link-1@example.com"}}
+	other := &fakeProvider{id: "other", profile: auth.Profile{Id: "link-1-other", Email: "link-1-other@example.com"}}
+	app := newTestApp(t, auth.AuthServiceOptions{Providers: []auth.Provider{primary, other}})
+	browser := newBrowser(app)
+
+	var user auth.User
+	decode(t, signIn(t, app, browser, primary), &user)
+
+	state := startLogin(t, app, browser, "/auth/link/other")
+	res := callback(t, app, browser, "other", state, nil)
+	var linked auth.User
+	decode(t, res, &linked)
+	if res.StatusCode != http.StatusOK || linked.Id != user.Id {
+		t.Fatalf("expected the account to be linked to %s, got %d %+v", user.Id, res.StatusCode, linked)
+	}
+
+	owner, err := adapters.Memory().GetUserByAccount("other", "link-1-other")
+	if err != nil || owner.Id != user.Id {
+		t.Errorf("expected the account to belong to %s, got %+v (%v)", user.Id, owner, err)
+	}
+}
+
+func TestLinkRequiresSession(t *testing.T) {
+	primary := &fakeProvider{id: "fake", profile: auth.Profile{Id: "link-2"}}
+	app := newTestApp(t, auth.AuthServiceOptions{Providers: []auth.Provider{primary}})
+
+	res := request(t, newBrowser(app), http.MethodGet, app.URL+"/auth/link/fake")
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a session, got %d", res.StatusCode)
+	}
+}
+
+// A link started by one user must not attach the account of whoever signs
+// in next in the same browser.
+func TestLinkIsBoundToTheUserWhoStartedIt(t *testing.T) {
+	primary := &fakeProvider{id: "fake", profile: auth.Profile{Id: "link-3-a"}}
+	other := &fakeProvider{id: "other", profile: auth.Profile{Id: "link-3-b"}}
+	app := newTestApp(t, auth.AuthServiceOptions{Providers: []auth.Provider{primary, other}})
+	browser := newBrowser(app)
+
+	var a auth.User
+	decode(t, signIn(t, app, browser, primary), &a)
+
+	// A starts linking, cancels at the provider and signs out.
+	state := startLogin(t, app, browser, "/auth/link/other")
+	if res := callback(t, app, browser, "other", state, url.Values{"error": {"access_denied"}}); res.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected the cancelled link to be denied, got %d", res.StatusCode)
+	}
+	request(t, browser, http.MethodPost, app.URL+"/auth/signout")
+
+	// B signs in with the provider A wanted to link.
+	var b auth.User
+	res := signIn(t, app, browser, other)
+	decode(t, res, &b)
+	if res.StatusCode != http.StatusOK || b.Id == "" || b.Id == a.Id {
+		t.Fatalf("expected B to sign in as a new user, got %d %+v", res.StatusCode, b)
+	}
+
+	// A starts linking again and signs out before the provider returns.
+	decode(t, signIn(t, app, browser, primary), &a)
+	state = startLogin(t, app, browser, "/auth/link/other")
+	request(t, browser, http.MethodPost, app.URL+"/auth/signout")
+	if res := callback(t, app, browser, "other", state, nil); res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected the link to be denied once A signed out, got %d", res.StatusCode)
+	}
+
+	owner, err := adapters.Memory().GetUserByAccount("other", "link-3-b")
+	if err != nil || owner.Id != b.Id {
+		t.Errorf("expected the account to stay with B, got %+v (%v)", owner, err)
+	}
+}
+
+func TestAccounts(t *testing.T) {
+	primary := &fakeProvider{id: "fake", profile: auth.Profile{Id: "accounts-1"}}
+	other := &fakeProvider{id: "other", profile: auth.Profile{Id: "accounts-1-other"}}
+	app := newTestApp(t, auth.AuthServiceOptions{Providers: []auth.Provider{primary, other}})
+	browser := newBrowser(app)
+
+	if res := request(t, browser, http.MethodGet, app.URL+"/auth/accounts"); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a session, got %d", res.StatusCode)
+	}
+
+	signIn(t, app, browser, primary)
+	state := startLogin(t, app, browser, "/auth/link/other")
+	callback(t, app, browser, "other", state, nil)
+
+	res := request(t, browser, http.MethodGet, app.URL+"/auth/accounts")
+	var body map[string][]map[string]interface{}
+	decode(t, res, &body)
+	if res.StatusCode != http.StatusOK || len(body["accounts"]) != 2 {
+		t.Fatalf("expected two accounts, got %d %v", res.StatusCode, body)
+	}
+	for _, account := range body["accounts"] {
+		for key := range account {
+			switch key {
+			case "id", "type", "provider", "providerAccountId":
+			default:
+				t.Errorf("expected only the public fields of the account, got %s", key)
+			}
+		}
+	}
+}
+
+func TestUnlink(t *testing.T) {
+	primary := &fakeProvider{id: "fake", profile: auth.Profile{Id: "unlink-1"}}
+	other := &fakeProvider{id: "other", profile: auth.Profile{Id: "unlink-1-other"}}
+	app := newTestApp(t, auth.AuthServiceOptions{Providers: []auth.Provider{primary, other}})
+	browser := newBrowser(app)
+
+	signIn(t, app, browser, primary)
+
+	if res := request(t, browser, http.MethodDelete, app.URL+"/auth/accounts/fake"); res.StatusCode != http.StatusConflict {
+		t.Errorf("expected the last sign-in method to be kept, got %d", res.StatusCode)
+	}
+	if res := request(t, browser, http.MethodDelete, app.URL+"/auth/accounts/unknown"); res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for a provider that is not linked, got %d", res.StatusCode)
+	}
+
+	state := startLogin(t, app, browser, "/auth/link/other")
+	callback(t, app, browser, "other", state, nil)
+
+	if res := request(t, browser, http.MethodDelete, app.URL+"/auth/accounts/fake"); res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected the account to be unlinked, got %d", res.StatusCode)
+	}
+	if _, err := adapters.Memory().GetUserByAccount("fake", "unlink-1"); err == nil {
+		t.Errorf("expected the account to be deleted")
+	}
+	if res := request(t, browser, http.MethodGet, app.URL+"/auth/session"); res.StatusCode != http.StatusOK {
+		t.Errorf("expected to stay signed in, got %d", res.StatusCode)
+	}
+}
+
+func TestEmailLinking(t *testing.T) {
+	tests := []struct {
+		name             string
+		allowLinking     bool
+		emailVerified    bool
+		existingVerified bool
+		linked           bool
+	}{
+		{"allowed and verified", true, true, true, true},
+		{"provider disallows linking", false, true, true, false},
+		{"email not verified", true, false, true, false},
+		{"existing email not verified", true, true, false, false},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := fmt.Sprintf("email-linking-%d@example.com", i)
+			existingUser := auth.User{Email: email}
+			if tt.existingVerified {
+				verifiedAt := "2026-01-01T00:00:00Z"
+				existingUser.EmailVerified = &verifiedAt
+			}
+			existing, err := adapters.Memory().CreateUser(existingUser, auth.Account{
+				Type:              "oauth",
+				Provider:          "existing",
+				ProviderAccountId: email,
+			})
+			if err != nil {
+				t.Fatalf("CreateUser() error = %v", err)
+			}
+
+			provider := &fakeProvider{
+				id:           "fake",
+				allowLinking: tt.allowLinking,
+				profile:      auth.Profile{Id: email, Email: email, EmailVerified: tt.emailVerified},
+			}
+			app := newTestApp(t, auth.AuthServiceOptions{Providers: []auth.Provider{provider}})
+
+			res := signIn(t, app, newBrowser(app), provider)
+			owner, ownerErr := adapters.Memory().GetUserByAccount("fake", email)
+
+			if !tt.linked {
+				var body map[string]string
+				decode(t, res, &body)
+				if res.StatusCode != http.StatusForbidden || body["error"] != string(auth.ErrorAccountNotLinked) {
+					t.Errorf("expected AccountNotLinked, got %d %v", res.StatusCode, body)
+				}
+				if ownerErr == nil {
+					t.Errorf("expected the account not to be stored, got user %s", owner.Id)
+				}
+				return
+			}
+
+			var user auth.User
+			decode(t, res, &user)
+			if res.StatusCode != http.StatusOK || user.Id != existing.Id {
+				t.Fatalf("expected to sign in as %s, got %d %+v", existing.Id, res.StatusCode, user)
+			}
+			if ownerErr != nil || owner.Id != existing.Id {
+				t.Errorf("expected the account to be linked to %s, got %+v (%v)", existing.Id, owner, ownerErr)
+			}
+		})
+	}
+}
+
+func TestLinkRefusesSecondAccountOfProvider(t *testing.T) {
+	primary := &fakeProvider{id: "fake", profile: auth.Profile{Id: "link-4"}}
+	other := &fakeProvider{id: "other", profile: auth.Profile{Id: "link-4-other"}}
+	app := newTestApp(t, auth.AuthServiceOptions{Providers: []auth.Provider{primary, other}})
+	browser := newBrowser(app)
+
+	signIn(t, app, browser, primary)
+	state := startLogin(t, app, browser, "/auth/link/other")
+	if res := callback(t, app, browser, "other", state, nil); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected the account to be linked, got %d", res.StatusCode)
+	}
+
+	other.profile = auth.Profile{Id: "link-4-other-second"}
+	state = startLogin(t, app, browser, "/auth/link/other")
+	res := callback(t, app, browser, "other", state, nil)
+	var body map[string]string
+	decode(t, res, &body)
+	if res.StatusCode != http.StatusConflict || body["error"] != string(auth.ErrorProviderLinked) {
+		t.Errorf("expected a second account of the provider to be refused, got %d %v", res.StatusCode, body)
+	}
+	if _, err := adapters.Memory().GetUserByAccount("other", "link-4-other-second"); err == nil {
+		t.Errorf("expected the second account not to be stored")
+	}
+}
+
+// Unlinking removes every account of the provider, so accounts of other
+// providers must remain.
+func TestUnlinkKeepsAnotherProvider(t *testing.T) {
+	primary := &fakeProvider{id: "fake", profile: auth.Profile{Id: "unlink-2"}}
+	app := newTestApp(t, auth.AuthServiceOptions{Providers: []auth.Provider{primary}})
+	browser := newBrowser(app)
+
+	var user auth.User
+	decode(t, signIn(t, app, browser, primary), &user)
+	if _, err := adapters.Memory().LinkAccount(auth.Account{UserId: user.Id, Type: "oauth", Provider: "fake", ProviderAccountId: "unlink-2-second"}); err != nil {
+		t.Fatalf("LinkAccount() error = %v", err)
+	}
+
+	if res := request(t, browser, http.MethodDelete, app.URL+"/auth/accounts/fake"); res.StatusCode != http.StatusConflict {
+		t.Errorf("expected the only provider to be kept, got %d", res.StatusCode)
+	}
+	if accounts, _ := adapters.Memory().GetAccounts(user.Id); len(accounts) != 2 {
+		t.Errorf("expected both accounts to remain, got %d", len(accounts))
+	}
+}
@@ -2,14 +2,34 @@ package auth
 
 import (
 	"time"
+
+	"github.com/golang-jwt/jwt"
 )
 
 type Adapter interface {
 	GetUserById(id string) (User, error)
 	GetUserByEmail(email string) (User, error)
 	GetUserBySessionToken(token string) (User, error)
+	GetUserByAccount(provider, providerAccountId string) (User, error)
 	CreateUser(user User, account Account) (User, error)
-	CreateSession(user User) (Session, error)
+	UpdateUser(user User) (User, error)
+	LinkAccount(account Account) (Account, error)
+	GetAccounts(userId string) ([]Account, error)
+	UpdateAccount(account Account) (Account, error)
+	// DeleteAccount removes the accounts of provider from the user.
+	DeleteAccount(userId, provider string) error
+	GetSessionAndUser(token string) (Session, User, error)
+	CreateSession(user User, expires time.Time) (Session, error)
+	UpdateSession(token string, expires time.Time) (Session, error)
+	DeleteSession(token string) error
+	DeleteUserSessions(userId string) error
+	DeleteExpiredSessions(now time.Time) (int64, error)
+	GetUserRoles(userId string) ([]string, error)
+	AddUserRole(userId, role string) error
+	RemoveUserRole(userId, role string) error
+	GetRolePermissions(roles []string) ([]string, error)
+	AddRolePermission(role, permission string) error
+	RemoveRolePermission(role, permission string) error
 }
 
 type Account struct {
@@ -32,10 +52,17 @@ type User struct {
 	Email         string  `json:"email"`
 	EmailVerified *string `json:"emailVerified" db:"email_verified"`
 	Image         string  `json:"image"`
+	// Roles and Permissions are loaded by the Service, not stored on the user.
+	Roles       []string `json:"roles,omitempty" db:"-"`
+	Permissions []string `json:"permissions,omitempty" db:"-"`
 }
 
 type Session struct {
 	SessionToken string    `json:"sessionToken" db:"session_token"`
 	UserId       string    `json:"userId" db:"user_id"`
 	Expires      time.Time `json:"expires"`
+	CreatedAt    time.Time `json:"createdAt" db:"created_at"`
+
+	// claims holds the decoded token of a jwt session.
+	claims jwt.MapClaims
 }
@@ -3,6 +3,8 @@ package adapters
 import (
 	"echo-server/internal/auth"
 	"fmt"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,25 +12,41 @@ import (
 
 type Memory_internal struct{}
 
+// mu guards the package level stores, which are shared by the request
+// handlers and the session reaper.
+var mu sync.RWMutex
+
 var users = []auth.User{}
 var accounts = []auth.Account{}
 var sessions = []auth.Session{}
+var userRoles = []userRole{}
+var rolePermissions = []rolePermission{}
+
+type userRole struct {
+	UserId string
+	Role   string
+}
+
+type rolePermission struct {
+	Role       string
+	Permission string
+}
 
 func Memory() Memory_internal {
 	return Memory_internal{}
 }
 
 func (a Memory_internal) GetUserById(id string) (auth.User, error) {
-	for _, u := range users {
-		if u.Id == id {
-			return u, nil
-		}
-	}
+	mu.RLock()
+	defer mu.RUnlock()
 
-	return auth.User{}, fmt.Errorf("user not found")
+	return findUser(id)
 }
 
 func (a Memory_internal) GetUserByEmail(email string) (auth.User, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
 	for _, u := range users {
 		if u.Email == email {
 			return u, nil
@@ -39,31 +57,30 @@ func (a Memory_internal) GetUserByEmail(email string) (auth.User, error) {
 }
 
 func (a Memory_internal) GetUserBySessionToken(token string) (auth.User, error) {
-	for _, s := range sessions {
-		if s.SessionToken == token {
-			for _, u := range users {
-				if u.Id == s.UserId {
-					return u, nil
-				}
-			}
-			break
+	_, user, err := a.GetSessionAndUser(token)
+	return user, err
+}
+
+func (a Memory_internal) GetUserByAccount(provider, providerAccountId string) (auth.User, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, acc := range accounts {
+		if acc.Provider == provider && acc.ProviderAccountId == providerAccountId {
+			return findUser(acc.UserId)
 		}
 	}
 
-	return auth.User{}, fmt.Errorf("user not found")
+	return auth.User{}, fmt.Errorf("account not found")
 }
 
 func (a Memory_internal) CreateUser(u auth.User, acc auth.Account) (auth.User, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
 	for _, account := range accounts {
-		if account.ProviderAccountId == acc.ProviderAccountId {
-			for _, user := range users {
-				if user.Id == acc.UserId {
-					return user, nil
-				} else {
-					return auth.User{}, fmt.Errorf("user not found")
-				}
-			}
-			break
+		if account.Provider == acc.Provider && account.ProviderAccountId == acc.ProviderAccountId {
+			return findUser(account.UserId)
 		}
 	}
 
@@ -95,15 +112,284 @@ func (a Memory_internal) CreateUser(u auth.User, acc auth.Account) (auth.User, e
 	return newUser, nil
 }
 
-func (a Memory_internal) CreateSession(user auth.User) (auth.Session, error) {
+func (a Memory_internal) UpdateUser(u auth.User) (auth.User, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for i, user := range users {
+		if user.Id == u.Id {
+			users[i].Name = u.Name
+			users[i].Email = u.Email
+			users[i].EmailVerified = u.EmailVerified
+			users[i].Image = u.Image
+			return users[i], nil
+		}
+	}
+
+	return auth.User{}, fmt.Errorf("user not found")
+}
+
+func (a Memory_internal) LinkAccount(acc auth.Account) (auth.Account, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, err := findUser(acc.UserId); err != nil {
+		return auth.Account{}, err
+	}
+
+	for _, account := range accounts {
+		if account.Provider == acc.Provider && account.ProviderAccountId == acc.ProviderAccountId {
+			return auth.Account{}, fmt.Errorf("account already linked")
+		}
+	}
+
+	acc.Id = uuid.New().String()
+	accounts = append(accounts, acc)
+
+	return acc, nil
+}
+
+func (a Memory_internal) GetAccounts(userId string) ([]auth.Account, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	userAccounts := []auth.Account{}
+	for _, account := range accounts {
+		if account.UserId == userId {
+			userAccounts = append(userAccounts, account)
+		}
+	}
+
+	return userAccounts, nil
+}
+
+func (a Memory_internal) UpdateAccount(acc auth.Account) (auth.Account, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for i, account := range accounts {
+		if account.Id == acc.Id {
+			accounts[i].RefreshToken = acc.RefreshToken
+			accounts[i].AccessToken = acc.AccessToken
+			accounts[i].ExpiresAt = acc.ExpiresAt
+			accounts[i].IdToken = acc.IdToken
+			accounts[i].Scope = acc.Scope
+			accounts[i].TokenType = acc.TokenType
+			return accounts[i], nil
+		}
+	}
+
+	return auth.Account{}, fmt.Errorf("account not found")
+}
+
+func (a Memory_internal) DeleteAccount(userId, provider string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	n := len(accounts)
+	accounts = slices.DeleteFunc(accounts, func(account auth.Account) bool {
+		return account.UserId == userId && account.Provider == provider
+	})
+	if len(accounts) == n {
+		return fmt.Errorf("account not found")
+	}
+	return nil
+}
+
+func (a Memory_internal) GetSessionAndUser(token string) (auth.Session, auth.User, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, s := range sessions {
+		if s.SessionToken == token {
+			if !s.Expires.After(time.Now()) {
+				return auth.Session{}, auth.User{}, fmt.Errorf("session expired")
+			}
+
+			user, err := findUser(s.UserId)
+			if err != nil {
+				return auth.Session{}, auth.User{}, err
+			}
+			return s, user, nil
+		}
+	}
+
+	return auth.Session{}, auth.User{}, fmt.Errorf("session not found")
+}
+
+func (a Memory_internal) CreateSession(user auth.User, expires time.Time) (auth.Session, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
 	sessionToken := uuid.New()
 	newSession := auth.Session{
 		SessionToken: sessionToken.String(),
 		UserId:       user.Id,
-		Expires:      time.Now().Add(5 * time.Minute),
+		Expires:      expires,
+		CreatedAt:    time.Now(),
 	}
 	sessions = append(sessions, newSession)
-	fmt.Println(sessions)
 
 	return newSession, nil
 }
+
+func (a Memory_internal) UpdateSession(token string, expires time.Time) (auth.Session, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for i, s := range sessions {
+		if s.SessionToken == token {
+			sessions[i].Expires = expires
+			return sessions[i], nil
+		}
+	}
+
+	return auth.Session{}, fmt.Errorf("session not found")
+}
+
+func (a Memory_internal) DeleteSession(token string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for i, s := range sessions {
+		if s.SessionToken == token {
+			sessions = append(sessions[:i], sessions[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+func (a Memory_internal) DeleteUserSessions(userId string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	deleteSessionsWhere(func(s auth.Session) bool {
+		return s.UserId == userId
+	})
+
+	return nil
+}
+
+func (a Memory_internal) DeleteExpiredSessions(now time.Time) (int64, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	deleted := deleteSessionsWhere(func(s auth.Session) bool {
+		return !s.Expires.After(now)
+	})
+
+	return int64(deleted), nil
+}
+
+func (a Memory_internal) GetUserRoles(userId string) ([]string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	roles := []string{}
+	for _, r := range userRoles {
+		if r.UserId == userId {
+			roles = append(roles, r.Role)
+		}
+	}
+
+	return roles, nil
+}
+
+func (a Memory_internal) AddUserRole(userId, role string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, err := findUser(userId); err != nil {
+		return err
+	}
+
+	for _, r := range userRoles {
+		if r.UserId == userId && r.Role == role {
+			return nil
+		}
+	}
+	userRoles = append(userRoles, userRole{UserId: userId, Role: role})
+
+	return nil
+}
+
+func (a Memory_internal) RemoveUserRole(userId, role string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for i, r := range userRoles {
+		if r.UserId == userId && r.Role == role {
+			userRoles = append(userRoles[:i], userRoles[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+func (a Memory_internal) GetRolePermissions(roles []string) ([]string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	permissions := []string{}
+	for _, p := range rolePermissions {
+		if slices.Contains(roles, p.Role) && !slices.Contains(permissions, p.Permission) {
+			permissions = append(permissions, p.Permission)
+		}
+	}
+
+	return permissions, nil
+}
+
+func (a Memory_internal) AddRolePermission(role, permission string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, p := range rolePermissions {
+		if p.Role == role && p.Permission == permission {
+			return nil
+		}
+	}
+	rolePermissions = append(rolePermissions, rolePermission{Role: role, Permission: permission})
+
+	return nil
+}
+
+func (a Memory_internal) RemoveRolePermission(role, permission string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for i, p := range rolePermissions {
+		if p.Role == role && p.Permission == permission {
+			rolePermissions = append(rolePermissions[:i], rolePermissions[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+func findUser(id string) (auth.User, error) {
+	for _, u := range users {
+		if u.Id == id {
+			return u, nil
+		}
+	}
+
+	return auth.User{}, fmt.Errorf("user not found")
+}
+
+func deleteSessionsWhere(match func(auth.Session) bool) int {
+	remaining := sessions[:0]
+	for _, s := range sessions {
+		if !match(s) {
+			remaining = append(remaining, s)
+		}
+	}
+	deleted := len(sessions) - len(remaining)
+	sessions = remaining
+
+	return deleted
+}
@@ -0,0 +1,58 @@
+package adapters
+
+import (
+	"reflect"
+	"testing"
+)
+
+// The memory stores are shared by the whole package, so the roles and
+// users here are named after the test.
+func TestMemoryRoles(t *testing.T) {
+	a := Memory()
+	user := createTestUser(t, a, "memory-roles")
+
+	if err := a.AddUserRole(user.Id, "memory-admin"); err != nil {
+		t.Fatalf("AddUserRole() error = %v", err)
+	}
+	if err := a.AddUserRole(user.Id, "memory-editor"); err != nil {
+		t.Fatalf("AddUserRole() error = %v", err)
+	}
+	if err := a.AddUserRole(user.Id, "memory-editor"); err != nil {
+		t.Fatalf("AddUserRole() error = %v", err)
+	}
+	if err := a.AddUserRole("missing", "memory-admin"); err == nil {
+		t.Errorf("expected role assignment to an unknown user to fail")
+	}
+
+	a.AddRolePermission("memory-admin", "memory:users:write")
+	a.AddRolePermission("memory-editor", "memory:posts:write")
+	a.AddRolePermission("memory-editor", "memory:users:write")
+
+	roles, err := a.GetUserRoles(user.Id)
+	if err != nil {
+		t.Fatalf("GetUserRoles() error = %v", err)
+	}
+	if !reflect.DeepEqual(roles, []string{"memory-admin", "memory-editor"}) {
+		t.Errorf("expected roles [memory-admin memory-editor], got %v", roles)
+	}
+
+	permissions, err := a.GetRolePermissions(roles)
+	if err != nil {
+		t.Fatalf("GetRolePermissions() error = %v", err)
+	}
+	if !reflect.DeepEqual(permissions, []string{"memory:users:write", "memory:posts:write"}) {
+		t.Errorf("expected permissions [memory:users:write memory:posts:write], got %v", permissions)
+	}
+
+	a.RemoveUserRole(user.Id, "memory-editor")
+	roles, _ = a.GetUserRoles(user.Id)
+	if !reflect.DeepEqual(roles, []string{"memory-admin"}) {
+		t.Errorf("expected roles [memory-admin], got %v", roles)
+	}
+
+	a.RemoveRolePermission("memory-admin", "memory:users:write")
+	permissions, _ = a.GetRolePermissions(roles)
+	if len(permissions) != 0 {
+		t.Errorf("expected no permissions, got %v", permissions)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"echo-server/internal/auth"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -45,13 +46,28 @@ func SQLite(dbPath string) SQLite_internal {
 		CREATE TABLE IF NOT EXISTS sessions (
 			session_token TEXT PRIMARY KEY,
 			user_id TEXT,
-			expires INTEGER
+			expires INTEGER,
+			created_at INTEGER
+		);
+		CREATE TABLE IF NOT EXISTS user_roles (
+			user_id TEXT,
+			role TEXT,
+			PRIMARY KEY (user_id, role)
+		);
+		CREATE TABLE IF NOT EXISTS role_permissions (
+			role TEXT,
+			permission TEXT,
+			PRIMARY KEY (role, permission)
 		);
 	`)
 	if err != nil {
 		panic(err)
 	}
 
+	if err := addColumn(db, "sessions", "created_at", "INTEGER"); err != nil {
+		panic(err)
+	}
+
 	return SQLite_internal{db: db}
 }
 
@@ -84,11 +100,16 @@ func (a SQLite_internal) GetUserByEmail(email string) (auth.User, error) {
 }
 
 func (a SQLite_internal) GetUserBySessionToken(token string) (auth.User, error) {
+	_, user, err := a.GetSessionAndUser(token)
+	return user, err
+}
+
+func (a SQLite_internal) GetUserByAccount(provider, providerAccountId string) (auth.User, error) {
 	var userId string
-	err := a.db.QueryRow("SELECT user_id FROM sessions WHERE session_token = ?", token).Scan(&userId)
+	err := a.db.QueryRow("SELECT user_id FROM accounts WHERE provider = ? AND provider_account_id = ?", provider, providerAccountId).Scan(&userId)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return auth.User{}, fmt.Errorf("session not found")
+			return auth.User{}, fmt.Errorf("account not found")
 		}
 		return auth.User{}, err
 	}
@@ -133,12 +154,130 @@ func (a SQLite_internal) CreateUser(u auth.User, acc auth.Account) (auth.User, e
 	return newUser, nil
 }
 
-func (a SQLite_internal) CreateSession(user auth.User) (auth.Session, error) {
+func (a SQLite_internal) UpdateUser(u auth.User) (auth.User, error) {
+	res, err := a.db.Exec("UPDATE users SET name = ?, email = ?, email_verified = ?, image = ? WHERE id = ?",
+		u.Name, u.Email, u.EmailVerified, u.Image, u.Id)
+	if err != nil {
+		return auth.User{}, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return auth.User{}, err
+	} else if n == 0 {
+		return auth.User{}, fmt.Errorf("user not found")
+	}
+
+	return a.GetUserById(u.Id)
+}
+
+func (a SQLite_internal) LinkAccount(acc auth.Account) (auth.Account, error) {
+	if _, err := a.GetUserById(acc.UserId); err != nil {
+		return auth.Account{}, err
+	}
+
+	if _, err := a.GetUserByAccount(acc.Provider, acc.ProviderAccountId); err == nil {
+		return auth.Account{}, fmt.Errorf("account already linked")
+	}
+
+	acc.Id = uuid.New().String()
+	_, err := a.db.Exec(`INSERT INTO accounts 
+		(id, user_id, type, provider, provider_account_id, refresh_token, access_token, expires_at, id_token, scope, token_type) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		acc.Id, acc.UserId, acc.Type, acc.Provider, acc.ProviderAccountId, acc.RefreshToken, acc.AccessToken, acc.ExpiresAt, acc.IdToken, acc.Scope, acc.TokenType)
+	if err != nil {
+		return auth.Account{}, err
+	}
+
+	return acc, nil
+}
+
+func (a SQLite_internal) GetAccounts(userId string) ([]auth.Account, error) {
+	rows, err := a.db.Query(`SELECT id, user_id, type, provider, provider_account_id, refresh_token, access_token, expires_at, id_token, scope, token_type
+		FROM accounts WHERE user_id = ?`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []auth.Account{}
+	for rows.Next() {
+		var acc auth.Account
+		if err := rows.Scan(&acc.Id, &acc.UserId, &acc.Type, &acc.Provider, &acc.ProviderAccountId, &acc.RefreshToken,
+			&acc.AccessToken, &acc.ExpiresAt, &acc.IdToken, &acc.Scope, &acc.TokenType); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, acc)
+	}
+
+	return accounts, rows.Err()
+}
+
+func (a SQLite_internal) UpdateAccount(acc auth.Account) (auth.Account, error) {
+	res, err := a.db.Exec(`UPDATE accounts SET refresh_token = ?, access_token = ?, expires_at = ?, id_token = ?, scope = ?, token_type = ?
+		WHERE id = ?`,
+		acc.RefreshToken, acc.AccessToken, acc.ExpiresAt, acc.IdToken, acc.Scope, acc.TokenType, acc.Id)
+	if err != nil {
+		return auth.Account{}, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return auth.Account{}, err
+	} else if n == 0 {
+		return auth.Account{}, fmt.Errorf("account not found")
+	}
+
+	var updated auth.Account
+	err = a.db.QueryRow(`SELECT id, user_id, type, provider, provider_account_id, refresh_token, access_token, expires_at, id_token, scope, token_type
+		FROM accounts WHERE id = ?`, acc.Id).Scan(&updated.Id, &updated.UserId, &updated.Type, &updated.Provider, &updated.ProviderAccountId,
+		&updated.RefreshToken, &updated.AccessToken, &updated.ExpiresAt, &updated.IdToken, &updated.Scope, &updated.TokenType)
+	if err != nil {
+		return auth.Account{}, err
+	}
+	return updated, nil
+}
+
+func (a SQLite_internal) DeleteAccount(userId, provider string) error {
+	res, err := a.db.Exec("DELETE FROM accounts WHERE user_id = ? AND provider = ?", userId, provider)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("account not found")
+	}
+	return nil
+}
+
+func (a SQLite_internal) GetSessionAndUser(token string) (auth.Session, auth.User, error) {
+	var session auth.Session
+	var expires, createdAt int64
+	err := a.db.QueryRow("SELECT session_token, user_id, expires, COALESCE(created_at, 0) FROM sessions WHERE session_token = ? AND expires > ?", token, time.Now().Unix()).Scan(
+		&session.SessionToken, &session.UserId, &expires, &createdAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return auth.Session{}, auth.User{}, fmt.Errorf("session not found")
+		}
+		return auth.Session{}, auth.User{}, err
+	}
+	session.Expires = time.Unix(expires, 0)
+	session.CreatedAt = time.Unix(createdAt, 0)
+
+	user, err := a.GetUserById(session.UserId)
+	if err != nil {
+		return auth.Session{}, auth.User{}, err
+	}
+	return session, user, nil
+}
+
+func (a SQLite_internal) CreateSession(user auth.User, expires time.Time) (auth.Session, error) {
 	sessionToken := uuid.New().String()
-	expiresAt := time.Now().Add(5 * time.Minute).Unix()
+	createdAt := time.Now().Unix()
 
-	_, err := a.db.Exec("INSERT INTO sessions (session_token, user_id, expires) VALUES (?, ?, ?)",
-		sessionToken, user.Id, expiresAt)
+	_, err := a.db.Exec("INSERT INTO sessions (session_token, user_id, expires, created_at) VALUES (?, ?, ?, ?)",
+		sessionToken, user.Id, expires.Unix(), createdAt)
 	if err != nil {
 		return auth.Session{}, err
 	}
@@ -146,8 +285,133 @@ func (a SQLite_internal) CreateSession(user auth.User) (auth.Session, error) {
 	newSession := auth.Session{
 		SessionToken: sessionToken,
 		UserId:       user.Id,
-		Expires:      time.Unix(expiresAt, 0),
+		Expires:      time.Unix(expires.Unix(), 0),
+		CreatedAt:    time.Unix(createdAt, 0),
 	}
 
 	return newSession, nil
 }
+
+func (a SQLite_internal) UpdateSession(token string, expires time.Time) (auth.Session, error) {
+	res, err := a.db.Exec("UPDATE sessions SET expires = ? WHERE session_token = ?", expires.Unix(), token)
+	if err != nil {
+		return auth.Session{}, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return auth.Session{}, err
+	} else if n == 0 {
+		return auth.Session{}, fmt.Errorf("session not found")
+	}
+
+	session, _, err := a.GetSessionAndUser(token)
+	return session, err
+}
+
+func (a SQLite_internal) DeleteSession(token string) error {
+	_, err := a.db.Exec("DELETE FROM sessions WHERE session_token = ?", token)
+	return err
+}
+
+func (a SQLite_internal) DeleteUserSessions(userId string) error {
+	_, err := a.db.Exec("DELETE FROM sessions WHERE user_id = ?", userId)
+	return err
+}
+
+func (a SQLite_internal) DeleteExpiredSessions(now time.Time) (int64, error) {
+	res, err := a.db.Exec("DELETE FROM sessions WHERE expires <= ?", now.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (a SQLite_internal) GetUserRoles(userId string) ([]string, error) {
+	return a.queryStrings("SELECT role FROM user_roles WHERE user_id = ? ORDER BY role", userId)
+}
+
+func (a SQLite_internal) AddUserRole(userId, role string) error {
+	if _, err := a.GetUserById(userId); err != nil {
+		return err
+	}
+
+	_, err := a.db.Exec("INSERT OR IGNORE INTO user_roles (user_id, role) VALUES (?, ?)", userId, role)
+	return err
+}
+
+func (a SQLite_internal) RemoveUserRole(userId, role string) error {
+	_, err := a.db.Exec("DELETE FROM user_roles WHERE user_id = ? AND role = ?", userId, role)
+	return err
+}
+
+func (a SQLite_internal) GetRolePermissions(roles []string) ([]string, error) {
+	if len(roles) == 0 {
+		return []string{}, nil
+	}
+
+	args := make([]interface{}, len(roles))
+	for i, role := range roles {
+		args[i] = role
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(roles)), ", ")
+	return a.queryStrings("SELECT DISTINCT permission FROM role_permissions WHERE role IN ("+placeholders+") ORDER BY permission", args...)
+}
+
+func (a SQLite_internal) AddRolePermission(role, permission string) error {
+	_, err := a.db.Exec("INSERT OR IGNORE INTO role_permissions (role, permission) VALUES (?, ?)", role, permission)
+	return err
+}
+
+func (a SQLite_internal) RemoveRolePermission(role, permission string) error {
+	_, err := a.db.Exec("DELETE FROM role_permissions WHERE role = ? AND permission = ?", role, permission)
+	return err
+}
+
+func (a SQLite_internal) queryStrings(query string, args ...interface{}) ([]string, error) {
+	rows, err := a.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
+
+// addColumn adds a column to a table created by an older version of the schema.
+func addColumn(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, colType    string
+			defaultValue     sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
@@ -0,0 +1,224 @@
+package adapters
+
+import (
+	"echo-server/internal/auth"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestSQLite(t *testing.T) SQLite_internal {
+	return SQLite(filepath.Join(t.TempDir(), "auth.db"))
+}
+
+func createTestUser(t *testing.T, a auth.Adapter, providerAccountId string) auth.User {
+	user, err := a.CreateUser(auth.User{Name: "Test", Email: providerAccountId + "@example.com"}, auth.Account{
+		Type:              "oauth",
+		Provider:          "test",
+		ProviderAccountId: providerAccountId,
+	})
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	return user
+}
+
+func TestSQLiteDeleteSession(t *testing.T) {
+	a := newTestSQLite(t)
+	user := createTestUser(t, a, "1")
+
+	session, err := a.CreateSession(user, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+
+	if _, err := a.GetUserBySessionToken(session.SessionToken); err != nil {
+		t.Fatalf("expected session to be valid, got %v", err)
+	}
+
+	if err := a.DeleteSession(session.SessionToken); err != nil {
+		t.Fatalf("DeleteSession() error = %v", err)
+	}
+
+	if _, err := a.GetUserBySessionToken(session.SessionToken); err == nil {
+		t.Errorf("expected deleted session to be invalid")
+	}
+}
+
+func TestSQLiteDeleteUserSessions(t *testing.T) {
+	a := newTestSQLite(t)
+	user := createTestUser(t, a, "1")
+	other := createTestUser(t, a, "2")
+
+	first, _ := a.CreateSession(user, time.Now().Add(time.Hour))
+	second, _ := a.CreateSession(user, time.Now().Add(time.Hour))
+	kept, _ := a.CreateSession(other, time.Now().Add(time.Hour))
+
+	if err := a.DeleteUserSessions(user.Id); err != nil {
+		t.Fatalf("DeleteUserSessions() error = %v", err)
+	}
+
+	for _, s := range []auth.Session{first, second} {
+		if _, err := a.GetUserBySessionToken(s.SessionToken); err == nil {
+			t.Errorf("expected session %s to be deleted", s.SessionToken)
+		}
+	}
+
+	if _, err := a.GetUserBySessionToken(kept.SessionToken); err != nil {
+		t.Errorf("expected other user's session to survive, got %v", err)
+	}
+}
+
+func TestSQLiteUpdateSession(t *testing.T) {
+	a := newTestSQLite(t)
+	user := createTestUser(t, a, "1")
+
+	session, err := a.CreateSession(user, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+
+	expires := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	updated, err := a.UpdateSession(session.SessionToken, expires)
+	if err != nil {
+		t.Fatalf("UpdateSession() error = %v", err)
+	}
+
+	if !updated.Expires.Equal(expires) {
+		t.Errorf("expected expires = %v, got %v", expires, updated.Expires)
+	}
+	if !updated.CreatedAt.Equal(session.CreatedAt) {
+		t.Errorf("expected createdAt to be preserved, got %v", updated.CreatedAt)
+	}
+}
+
+func TestSQLiteExpiredSessions(t *testing.T) {
+	a := newTestSQLite(t)
+	user := createTestUser(t, a, "1")
+
+	expired, _ := a.CreateSession(user, time.Now().Add(-time.Minute))
+	active, _ := a.CreateSession(user, time.Now().Add(time.Hour))
+
+	if _, err := a.GetUserBySessionToken(expired.SessionToken); err == nil {
+		t.Errorf("expected expired session to be rejected")
+	}
+
+	deleted, err := a.DeleteExpiredSessions(time.Now())
+	if err != nil {
+		t.Fatalf("DeleteExpiredSessions() error = %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("expected 1 deleted session, got %d", deleted)
+	}
+
+	if _, err := a.GetUserBySessionToken(active.SessionToken); err != nil {
+		t.Errorf("expected active session to survive, got %v", err)
+	}
+}
+
+func TestSQLiteRoles(t *testing.T) {
+	a := newTestSQLite(t)
+	user := createTestUser(t, a, "1")
+
+	if err := a.AddUserRole(user.Id, "admin"); err != nil {
+		t.Fatalf("AddUserRole() error = %v", err)
+	}
+	if err := a.AddUserRole(user.Id, "editor"); err != nil {
+		t.Fatalf("AddUserRole() error = %v", err)
+	}
+	if err := a.AddUserRole("missing", "admin"); err == nil {
+		t.Errorf("expected role assignment to an unknown user to fail")
+	}
+
+	a.AddRolePermission("admin", "users:write")
+	a.AddRolePermission("editor", "posts:write")
+	a.AddRolePermission("editor", "users:write")
+
+	roles, err := a.GetUserRoles(user.Id)
+	if err != nil {
+		t.Fatalf("GetUserRoles() error = %v", err)
+	}
+	if !reflect.DeepEqual(roles, []string{"admin", "editor"}) {
+		t.Errorf("expected roles [admin editor], got %v", roles)
+	}
+
+	permissions, err := a.GetRolePermissions(roles)
+	if err != nil {
+		t.Fatalf("GetRolePermissions() error = %v", err)
+	}
+	if !reflect.DeepEqual(permissions, []string{"posts:write", "users:write"}) {
+		t.Errorf("expected permissions [posts:write users:write], got %v", permissions)
+	}
+
+	a.RemoveUserRole(user.Id, "editor")
+	roles, _ = a.GetUserRoles(user.Id)
+	if !reflect.DeepEqual(roles, []string{"admin"}) {
+		t.Errorf("expected roles [admin], got %v", roles)
+	}
+}
+
+func TestSQLiteLinkAccount(t *testing.T) {
+	a := newTestSQLite(t)
+	user := createTestUser(t, a, "1")
+
+	account := auth.Account{UserId: user.Id, Type: "oauth", Provider: "other", ProviderAccountId: "42"}
+	if _, err := a.LinkAccount(account); err != nil {
+		t.Fatalf("LinkAccount() error = %v", err)
+	}
+
+	linked, err := a.GetUserByAccount("other", "42")
+	if err != nil {
+		t.Fatalf("GetUserByAccount() error = %v", err)
+	}
+	if linked.Id != user.Id {
+		t.Errorf("expected account to belong to %s, got %s", user.Id, linked.Id)
+	}
+
+	if _, err := a.LinkAccount(account); err == nil {
+		t.Errorf("expected linking the same account twice to fail")
+	}
+
+	accounts, err := a.GetAccounts(user.Id)
+	if err != nil {
+		t.Fatalf("GetAccounts() error = %v", err)
+	}
+	if len(accounts) != 2 {
+		t.Fatalf("expected 2 accounts, got %d", len(accounts))
+	}
+
+	if err := a.DeleteAccount(user.Id, "other"); err != nil {
+		t.Fatalf("DeleteAccount() error = %v", err)
+	}
+	if _, err := a.GetUserByAccount("other", "42"); err == nil {
+		t.Errorf("expected unlinked account to be gone")
+	}
+}
+
+func TestSQLiteUpdateAccount(t *testing.T) {
+	a := newTestSQLite(t)
+	user := createTestUser(t, a, "1")
+
+	accounts, err := a.GetAccounts(user.Id)
+	if err != nil || len(accounts) != 1 {
+		t.Fatalf("GetAccounts() = %v, %v", accounts, err)
+	}
+
+	refreshToken := "rotated"
+	account := accounts[0]
+	account.AccessToken = "renewed"
+	account.RefreshToken = &refreshToken
+	account.ExpiresAt = 1700000000
+
+	updated, err := a.UpdateAccount(account)
+	if err != nil {
+		t.Fatalf("UpdateAccount() error = %v", err)
+	}
+	if !reflect.DeepEqual(updated, account) {
+		t.Errorf("expected %+v, got %+v", account, updated)
+	}
+
+	if _, err := a.UpdateAccount(auth.Account{Id: "missing"}); err == nil {
+		t.Errorf("expected an error for an unknown account")
+	}
+}
@@ -1,20 +1,68 @@
 package auth
 
 import (
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
 
+// stateMaxAge is how long a user may take to sign in at the provider.
+const stateMaxAge = 10 * time.Minute
+
 type Service struct {
-	providers *Providers
-	adapter   *Adapter
+	providers     *Providers
+	adapter       *Adapter
+	session       SessionOptions
+	pages         Pages
+	callbacks     Callbacks
+	publicURL     string
+	trustProxy    bool
+	redirectHosts []string
+	deviceClients []string
+	devices       *deviceStore
+	events        *eventBus
+	reaper        *reaper
+	// refreshLocks serializes provider token refreshes per account.
+	refreshLocks *keyedMutex
 }
 
 type AuthServiceOptions struct {
 	Providers []Provider
 	Adapter   Adapter
+	Session   SessionOptions
+	Pages     Pages
+	Callbacks Callbacks
+	Events    []Subscriber
+	// BaseURL is the public URL of the server, e.g. "https://example.com".
+	// It is used to build the redirect_uri of every provider. When empty it
+	// is derived from the request.
+	BaseURL string
+	// TrustProxy honors X-Forwarded-Proto and X-Forwarded-Host when deriving
+	// the base URL, and X-Forwarded-For for the IP of events. Only enable it
+	// behind a proxy that sets these headers.
+	TrustProxy bool
+	// AllowedRedirectHosts lists the hosts, besides this server, that
+	// callbackUrl may point to, e.g. "app.example.com".
+	AllowedRedirectHosts []string
+	// DeviceClients lists the client_ids, e.g. of a CLI, that may sign in
+	// with the device flow. The device flow is disabled when empty.
+	DeviceClients []string
+}
+
+type Pages struct {
+	// SignIn is where browsers are redirected when a protected route needs a
+	// session. The original URL is passed along as callbackUrl.
+	SignIn string
+	// Error is where browsers are redirected when signing in fails. The
+	// ErrorCode is passed along as error. When empty the built-in error
+	// page is rendered.
+	Error string
 }
 
 func New(opts AuthServiceOptions) Service {
@@ -24,10 +72,39 @@ func New(opts AuthServiceOptions) Service {
 		providerMap[p.GetId()] = p
 	}
 
-	return Service{
-		providers: &providerMap,
-		adapter:   &opts.Adapter,
+	service := Service{
+		providers:     &providerMap,
+		adapter:       &opts.Adapter,
+		session:       opts.Session.withDefaults(),
+		pages:         opts.Pages,
+		callbacks:     opts.Callbacks,
+		publicURL:     strings.TrimSuffix(opts.BaseURL, "/"),
+		trustProxy:    opts.TrustProxy,
+		redirectHosts: opts.AllowedRedirectHosts,
+		deviceClients: opts.DeviceClients,
+		devices:       newDeviceStore(),
+		events:        newEventBus(opts.Events),
+		refreshLocks:  &keyedMutex{},
+	}
+
+	if service.session.Strategy == SessionStrategyJWT && service.session.JWT.Secret == "" {
+		panic("auth: the jwt session strategy requires AUTH_SECRET")
+	}
+
+	if opts.Adapter != nil && service.session.Strategy == SessionStrategyDatabase && service.session.PurgeInterval > 0 {
+		service.reaper = startReaper(opts.Adapter, service.session.PurgeInterval)
 	}
+
+	return service
+}
+
+// Close stops the background work started by New and waits for
+// asynchronous event subscribers to finish.
+func (s *Service) Close() {
+	if s.reaper != nil {
+		s.reaper.Stop()
+	}
+	s.events.wait()
 }
 
 func (s *Service) Providers(c echo.Context) error {
@@ -54,33 +131,137 @@ func (s *Service) Login(c echo.Context) error {
 		})
 	}
 
-	callbackUrl := fmt.Sprintf("http://%s/auth/callback/%s", c.Request().Host, provider.GetId())
-	return c.Redirect(http.StatusTemporaryRedirect, provider.GetRedirectURL(callbackUrl))
+	return s.redirectToProvider(c, provider, "")
+}
+
+// redirectToProvider starts the sign in flow of provider. With linkUserId
+// the resulting account is attached to that user instead of signing in.
+func (s *Service) redirectToProvider(c echo.Context, provider Provider, linkUserId string) error {
+	params := AuthorizationParams{
+		RedirectURI: s.callbackURL(c, provider.GetId()),
+		State:       GenerateState(),
+	}
+	checks := provider.GetChecks()
+
+	state, err := json.Marshal(loginState{
+		State:       params.State,
+		CallbackURL: s.redirectURL(c, c.QueryParam("callbackUrl")),
+		LinkUserId:  linkUserId,
+	})
+	if err != nil {
+		return err
+	}
+	setSignedCookie(c, stateCookieName, string(state), stateMaxAge, checks.FormPost)
+
+	if checks.PKCE {
+		params.CodeVerifier = GenerateCodeVerifier()
+		setSignedCookie(c, pkceCookieName, params.CodeVerifier, stateMaxAge, checks.FormPost)
+	}
+
+	if checks.Nonce {
+		params.Nonce = generateRandomString(32)
+		setSignedCookie(c, nonceCookieName, params.Nonce, stateMaxAge, checks.FormPost)
+	}
+
+	return c.Redirect(http.StatusTemporaryRedirect, provider.GetRedirectURL(params))
+}
+
+// loginState is what Login remembers in the state cookie for the callback.
+type loginState struct {
+	State string `json:"state"`
+	// CallbackURL is the validated destination after signing in.
+	CallbackURL string `json:"callbackUrl,omitempty"`
+	// LinkUserId is the user who started Link. The account is only linked
+	// while this user is still signed in at the callback.
+	LinkUserId string `json:"linkUserId,omitempty"`
+}
+
+// verifyState checks that the state returned by the provider is the one
+// issued to this browser by Login.
+func (s *Service) verifyState(c echo.Context) (loginState, error) {
+	var expected loginState
+	value, err := readSignedCookie(c, stateCookieName)
+	if err != nil {
+		return expected, fmt.Errorf("invalid state")
+	}
+	if err := json.Unmarshal([]byte(value), &expected); err != nil {
+		return expected, fmt.Errorf("invalid state")
+	}
+
+	// FormValue covers providers that post the callback as a form.
+	state := c.FormValue("state")
+	if state == "" || !hmac.Equal([]byte(state), []byte(expected.State)) {
+		return expected, fmt.Errorf("invalid state")
+	}
+
+	return expected, VerifyState(state, stateMaxAge)
+}
+
+// signedIn sends the browser to the destination requested at Login, or
+// answers with the user when there is none.
+func (s *Service) signedIn(c echo.Context, target string, user User) error {
+	if target != "" {
+		return c.Redirect(http.StatusSeeOther, target)
+	}
+
+	return c.JSON(http.StatusOK, user)
 }
 
 func (s *Service) Callback(c echo.Context) error {
+	// The sign in cookies are single use, whatever the outcome. They are
+	// still read from the request below.
+	clearSignInCookies(c)
+
 	providerId := c.Param("provider")
 	provider, ok := (*s.providers)[providerId]
 
 	fail := func(err error) error {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+		return s.signInFailed(c, err)
 	}
 
 	if !ok {
-		return fail(fmt.Errorf("provider not found"))
+		return fail(NewSignInError(ErrorUnknownProvider, fmt.Errorf("provider %q not found", providerId)))
 	}
 
-	profile, tokenSet, err := provider.HandleCallback(c.Request())
+	state, err := s.verifyState(c)
+	if err != nil {
+		return fail(NewSignInError(ErrorInvalidState, err))
+	}
+
+	params := AuthorizationParams{
+		RedirectURI: s.callbackURL(c, provider.GetId()),
+	}
+	if provider.GetChecks().PKCE {
+		verifier, err := readSignedCookie(c, pkceCookieName)
+		if err != nil {
+			return fail(NewSignInError(ErrorInvalidState, err))
+		}
+		params.CodeVerifier = verifier
+	}
+
+	if provider.GetChecks().Nonce {
+		nonce, err := readSignedCookie(c, nonceCookieName)
+		if err != nil {
+			return fail(NewSignInError(ErrorInvalidState, err))
+		}
+		params.Nonce = nonce
+	}
+
+	profile, tokenSet, err := provider.HandleCallback(c.Request().Context(), c.Request(), params)
 	if err != nil {
 		return fail(err)
 	}
+	// Accounts are looked up by this id, an empty one would sign every
+	// user of the provider in as the first.
+	if profile.Id == "" {
+		return fail(NewSignInError(ErrorInvalidProfile, fmt.Errorf("provider %q returned a profile without an id", providerId)))
+	}
 
 	user := User{
-		Name:  profile.Name,
-		Email: profile.Email,
-		Image: profile.Picture,
+		Name:          profile.Name,
+		Email:         profile.Email,
+		EmailVerified: emailVerifiedAt(profile),
+		Image:         profile.Picture,
 	}
 
 	account := Account{
@@ -95,44 +276,151 @@ func (s *Service) Callback(c echo.Context) error {
 		TokenType:         tokenSet.TokenType,
 	}
 
-	u, err := (*s.adapter).CreateUser(user, account)
+	if s.callbacks.SignIn != nil {
+		redirect, err := s.callbacks.SignIn(SignInParams{
+			User:    user,
+			Account: account,
+			Profile: profile,
+		})
+		if err != nil {
+			var signInErr *SignInError
+			if !errors.As(err, &signInErr) {
+				err = NewSignInError(ErrorAccessDenied, err)
+			}
+			return fail(err)
+		}
+		if redirect != "" {
+			return c.Redirect(http.StatusSeeOther, redirect)
+		}
+	}
+
+	if state.LinkUserId != "" {
+		if _, current, err := s.resolveSession(c); err != nil || current.Id != state.LinkUserId {
+			return fail(NewSignInError(ErrorAccessDenied, errors.New("the user who started linking is not signed in")))
+		}
+
+		u, err := s.linkAccount(c, state.LinkUserId, account)
+		if err != nil {
+			return fail(err)
+		}
+
+		return s.signedIn(c, state.CallbackURL, u)
+	}
+
+	u, isNewUser, err := s.signInUser(c, provider, user, account, profile)
 	if err != nil {
 		return fail(err)
 	}
 
-	session, err := (*s.adapter).CreateSession(u)
-	if err != nil || session.SessionToken == "" {
+	if _, err := s.createSession(c, SignInParams{User: u, Account: account, Profile: profile}); err != nil {
 		return fail(err)
 	}
-	fmt.Println(session)
 
-	c.SetCookie(&http.Cookie{
-		Name:     "session",
-		Value:    session.SessionToken,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
-		Expires:  session.Expires,
-	})
-	return c.JSON(http.StatusOK, u)
+	if isNewUser {
+		s.publish(c, Event{Type: EventSignUp, User: u, Account: &account, Provider: account.Provider})
+	}
+	s.publish(c, Event{Type: EventSignIn, User: u, Account: &account, Provider: account.Provider})
+
+	return s.signedIn(c, state.CallbackURL, u)
 }
 
 func (s *Service) Session(c echo.Context) error {
-	cookies := c.Request().Cookies()
-	var sessionToken string
-	for _, cookie := range cookies {
-		if cookie.Name == "session" {
-			sessionToken = cookie.Value
+	if _, _, err := s.resolveSession(c); err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "invalid session",
+		})
+	}
+
+	user, err := s.resolveAuthorization(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	if s.callbacks.Session == nil {
+		return c.JSON(http.StatusOK, user)
+	}
+
+	session, _ := GetSession(c)
+	resp, err := s.callbacks.Session(SessionParams{
+		Session: session,
+		User:    user,
+		Token:   session.claims,
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// SignOut ends the session of the current device. With revoke=true the
+// provider tokens of the user are revoked as well.
+func (s *Service) SignOut(c echo.Context) error {
+	session, user, err := s.resolveSession(c)
+	if err == nil && s.session.Strategy == SessionStrategyDatabase {
+		if err := (*s.adapter).DeleteSession(session.SessionToken); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": err.Error(),
+			})
+		}
+	}
+	if err == nil && c.FormValue("revoke") == "true" {
+		if err := s.revokeUserTokens(c.Request().Context(), user.Id); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": err.Error(),
+			})
 		}
 	}
 
-	user, err := (*s.adapter).GetUserBySessionToken(sessionToken)
+	clearSessionCookie(c)
+	if err == nil {
+		s.publish(c, Event{Type: EventSignOut, User: user})
+	}
+	return s.signedOut(c)
+}
+
+// SignOutAll ends every session of the current user. With revoke=true the
+// provider tokens of the user are revoked as well.
+func (s *Service) SignOutAll(c echo.Context) error {
+	if s.session.Strategy != SessionStrategyDatabase {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "signing out everywhere requires database sessions",
+		})
+	}
+
+	_, user, err := s.resolveSession(c)
 	if err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]string{
-			"error": "invalid session",
+		return s.unauthorized(c)
+	}
+
+	if err := (*s.adapter).DeleteUserSessions(user.Id); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
 		})
 	}
+	if c.FormValue("revoke") == "true" {
+		if err := s.revokeUserTokens(c.Request().Context(), user.Id); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": err.Error(),
+			})
+		}
+	}
 
-	return c.JSON(http.StatusOK, user)
+	clearSessionCookie(c)
+	s.publish(c, Event{Type: EventSignOut, User: user})
+	return s.signedOut(c)
+}
+
+func (s *Service) signedOut(c echo.Context) error {
+	if target := s.redirectURL(c, c.FormValue("callbackUrl")); target != "" {
+		return c.Redirect(http.StatusSeeOther, target)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "signed out",
+	})
 }
@@ -0,0 +1,456 @@
+package auth_test
+
+import (
+	"context"
+	"echo-server/internal/auth"
+	"echo-server/internal/auth/adapters"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+)
+
+// fakeProvider signs in with profile, without calling out to a provider.
+// It answers the callback like a provider would when the request carries
+// an error, e.g. after the user cancelled.
+type fakeProvider struct {
+	id           string
+	profile      auth.Profile
+	allowLinking bool
+}
+
+func (p *fakeProvider) GetId() string            { return p.id }
+func (p *fakeProvider) GetType() string          { return "oauth" }
+func (p *fakeProvider) AllowsEmailLinking() bool { return p.allowLinking }
+func (p *fakeProvider) GetChecks() auth.Checks   { return auth.Checks{PKCE: true, Nonce: true} }
+
+func (p *fakeProvider) GetPublicData() auth.ProviderData {
+	return auth.ProviderData{Id: p.id, Name: p.id, Type: "oauth"}
+}
+
+func (p *fakeProvider) GetRedirectURL(params auth.AuthorizationParams) string {
+	return "https://idp.example.com/authorize?" + url.Values{"state": {params.State}}.Encode()
+}
+
+func (p *fakeProvider) HandleCallback(ctx context.Context, request *http.Request, params auth.AuthorizationParams) (auth.Profile, auth.TokenSet, error) {
+	if request.FormValue("error") != "" {
+		return auth.Profile{}, auth.TokenSet{}, auth.NewSignInError(auth.ErrorAccessDenied, errors.New(request.FormValue("error")))
+	}
+	if params.CodeVerifier == "" || params.Nonce == "" {
+		return auth.Profile{}, auth.TokenSet{}, errors.New("missing checks")
+	}
+	return p.profile, auth.TokenSet{AccessToken: "access-" + p.profile.Id, RefreshToken: "refresh-" + p.profile.Id}, nil
+}
+
+// newTestService defaults to the memory adapter, which is shared by all
+// tests, so they use distinct profiles.
+func newTestService(t *testing.T, opts auth.AuthServiceOptions) *auth.Service {
+	if opts.Adapter == nil {
+		opts.Adapter = adapters.Memory()
+	}
+	opts.Session.PurgeInterval = -1
+
+	service := auth.New(opts)
+	t.Cleanup(service.Close)
+	return &service
+}
+
+// newTestApp serves the auth routes of a new service.
+func newTestApp(t *testing.T, opts auth.AuthServiceOptions) *httptest.Server {
+	return serve(t, newTestService(t, opts))
+}
+
+// serve serves the auth routes, and any routes added by the test, over TLS,
+// as the auth cookies are Secure.
+func serve(t *testing.T, service *auth.Service, routes ...func(e *echo.Echo)) *httptest.Server {
+	e := echo.New()
+	e.GET("/auth/login/:provider", service.Login)
+	e.GET("/auth/callback/:provider", service.Callback)
+	e.POST("/auth/callback/:provider", service.Callback)
+	e.GET("/auth/session", service.Session)
+	e.POST("/auth/signout", service.SignOut)
+	e.POST("/auth/signout/all", service.SignOutAll)
+	e.GET("/auth/link/:provider", service.Link)
+	e.GET("/auth/accounts", service.Accounts)
+	e.DELETE("/auth/accounts/:provider", service.Unlink)
+	for _, route := range routes {
+		route(e)
+	}
+
+	app := httptest.NewTLSServer(e)
+	t.Cleanup(app.Close)
+	return app
+}
+
+// newBrowser returns a client with its own cookies that does not follow
+// redirects, so the tests play the part of the provider.
+func newBrowser(app *httptest.Server) *http.Client {
+	// app.Client is shared, copy it to keep the cookies apart.
+	client := *app.Client()
+	client.Jar, _ = cookiejar.New(nil)
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &client
+}
+
+func request(t *testing.T, browser *http.Client, method, target string) *http.Response {
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+	res, err := browser.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, target, err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+// startLogin follows path, Login or Link, up to the provider and returns the
+// state it was sent.
+func startLogin(t *testing.T, app *httptest.Server, browser *http.Client, path string) string {
+	res := request(t, browser, http.MethodGet, app.URL+path)
+	if res.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("expected a redirect to the provider, got %d", res.StatusCode)
+	}
+	location, err := url.Parse(res.Header.Get(echo.HeaderLocation))
+	if err != nil {
+		t.Fatalf("invalid redirect: %v", err)
+	}
+	return location.Query().Get("state")
+}
+
+// callback returns from the provider with state and the extra query.
+func callback(t *testing.T, app *httptest.Server, browser *http.Client, providerId, state string, query url.Values) *http.Response {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("code", "code")
+	query.Set("state", state)
+	return request(t, browser, http.MethodGet, app.URL+"/auth/callback/"+providerId+"?"+query.Encode())
+}
+
+// signIn runs the whole sign in with provider in browser.
+func signIn(t *testing.T, app *httptest.Server, browser *http.Client, provider *fakeProvider) *http.Response {
+	state := startLogin(t, app, browser, "/auth/login/"+provider.id)
+	return callback(t, app, browser, provider.id, state, nil)
+}
+
+func decode(t *testing.T, res *http.Response, target interface{}) {
+	if err := json.NewDecoder(res.Body).Decode(target); err != nil {
+		t.Fatalf("decoding the response: %v", err)
+	}
+}
+
+func TestCallbackSignsIn(t *testing.T) {
+	provider := &fakeProvider{id: "fake", profile: auth.Profile{Id: "callback-1", Email: "callback-1@example.com"}}
+	app := newTestApp(t, auth.AuthServiceOptions{Providers: []auth.Provider{provider}})
+	browser := newBrowser(app)
+
+	res := signIn(t, app, browser, provider)
+	var user auth.User
+	decode(t, res, &user)
+	if res.StatusCode != http.StatusOK || user.Email != "callback-1@example.com" {
+		t.Fatalf("expected to sign in, got %d %+v", res.StatusCode, user)
+	}
+
+	res = request(t, browser, http.MethodGet, app.URL+"/auth/session")
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected a session after signing in, got %d", res.StatusCode)
+	}
+}
+
+func TestCallbackRejectsStateOfAnotherBrowser(t *testing.T) {
+	provider := &fakeProvider{id: "fake", profile: auth.Profile{Id: "callback-2"}}
+	app := newTestApp(t, auth.AuthServiceOptions{Providers: []auth.Provider{provider}})
+	victim, attacker := newBrowser(app), newBrowser(app)
+
+	startLogin(t, app, victim, "/auth/login/fake")
+	state := startLogin(t, app, attacker, "/auth/login/fake")
+
+	res := callback(t, app, victim, "fake", state, nil)
+	var body map[string]string
+	decode(t, res, &body)
+	if res.StatusCode != http.StatusBadRequest || body["error"] != string(auth.ErrorInvalidState) {
+		t.Errorf("expected the state of another browser to be rejected, got %d %v", res.StatusCode, body)
+	}
+
+	res = request(t, victim, http.MethodGet, app.URL+"/auth/session")
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected no session, got %d", res.StatusCode)
+	}
+}
+
+func TestCallbackRejectsReplayedState(t *testing.T) {
+	provider := &fakeProvider{id: "fake", profile: auth.Profile{Id: "callback-3"}}
+	app := newTestApp(t, auth.AuthServiceOptions{Providers: []auth.Provider{provider}})
+	browser := newBrowser(app)
+
+	state := startLogin(t, app, browser, "/auth/login/fake")
+	if res := callback(t, app, browser, "fake", state, nil); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected to sign in, got %d", res.StatusCode)
+	}
+
+	res := callback(t, app, browser, "fake", state, nil)
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected the replayed state to be rejected, got %d", res.StatusCode)
+	}
+}
+
+func TestCallbackClearsSignInCookiesOnFailure(t *testing.T) {
+	provider := &fakeProvider{id: "fake", profile: auth.Profile{Id: "callback-4"}}
+	app := newTestApp(t, auth.AuthServiceOptions{Providers: []auth.Provider{provider}})
+	browser := newBrowser(app)
+
+	startLogin(t, app, browser, "/auth/login/fake")
+	res := callback(t, app, browser, "fake", "forged", nil)
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected the forged state to be rejected, got %d", res.StatusCode)
+	}
+
+	appURL, _ := url.Parse(app.URL)
+	if cookies := browser.Jar.Cookies(appURL); len(cookies) != 0 {
+		t.Errorf("expected the sign in cookies to be cleared, got %v", cookies)
+	}
+}
+
+func TestSignInCallbackDenies(t *testing.T) {
+	provider := &fakeProvider{id: "fake", profile: auth.Profile{Id: "hooks-1"}}
+	app := newTestApp(t, auth.AuthServiceOptions{
+		Providers: []auth.Provider{provider},
+		Callbacks: auth.Callbacks{
+			SignIn: func(params auth.SignInParams) (string, error) {
+				return "", errors.New("banned")
+			},
+		},
+	})
+	browser := newBrowser(app)
+
+	res := signIn(t, app, browser, provider)
+	var body map[string]string
+	decode(t, res, &body)
+	if res.StatusCode != http.StatusForbidden || body["error"] != string(auth.ErrorAccessDenied) {
+		t.Errorf("expected the sign in to be denied, got %d %v", res.StatusCode, body)
+	}
+
+	if res := request(t, browser, http.MethodGet, app.URL+"/auth/session"); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected no session, got %d", res.StatusCode)
+	}
+}
+
+func TestSignInCallbackRedirects(t *testing.T) {
+	provider := &fakeProvider{id: "fake", profile: auth.Profile{Id: "hooks-2"}}
+	app := newTestApp(t, auth.AuthServiceOptions{
+		Providers: []auth.Provider{provider},
+		Callbacks: auth.Callbacks{
+			SignIn: func(params auth.SignInParams) (string, error) {
+				return "/verify-request", nil
+			},
+		},
+	})
+	browser := newBrowser(app)
+
+	res := signIn(t, app, browser, provider)
+	if res.StatusCode != http.StatusSeeOther || res.Header.Get(echo.HeaderLocation) != "/verify-request" {
+		t.Errorf("expected a redirect to /verify-request, got %d %s", res.StatusCode, res.Header.Get(echo.HeaderLocation))
+	}
+
+	if res := request(t, browser, http.MethodGet, app.URL+"/auth/session"); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected no session, got %d", res.StatusCode)
+	}
+	if _, err := adapters.Memory().GetUserByAccount("fake", "hooks-2"); err == nil {
+		t.Errorf("expected no user to be created")
+	}
+}
+
+func TestSessionCallback(t *testing.T) {
+	provider := &fakeProvider{id: "fake", profile: auth.Profile{Id: "hooks-3", Email: "hooks-3@example.com"}}
+	app := newTestApp(t, auth.AuthServiceOptions{
+		Providers: []auth.Provider{provider},
+		Callbacks: auth.Callbacks{
+			Session: func(params auth.SessionParams) (interface{}, error) {
+				return map[string]string{"email": params.User.Email, "plan": "free"}, nil
+			},
+		},
+	})
+	browser := newBrowser(app)
+	signIn(t, app, browser, provider)
+
+	res := request(t, browser, http.MethodGet, app.URL+"/auth/session")
+	var body map[string]string
+	decode(t, res, &body)
+	if res.StatusCode != http.StatusOK || len(body) != 2 || body["email"] != "hooks-3@example.com" || body["plan"] != "free" {
+		t.Errorf("expected the session shaped by the callback, got %d %v", res.StatusCode, body)
+	}
+}
+
+func TestJWTCallback(t *testing.T) {
+	provider := &fakeProvider{id: "fake", profile: auth.Profile{Id: "hooks-4"}}
+	app := newTestApp(t, auth.AuthServiceOptions{
+		Providers: []auth.Provider{provider},
+		Session: auth.SessionOptions{
+			Strategy: auth.SessionStrategyJWT,
+			JWT:      auth.JWTOptions{Secret: "test-secret"},
+		},
+		Callbacks: auth.Callbacks{
+			JWT: func(params auth.JWTParams) (jwt.MapClaims, error) {
+				params.Token["provider"] = params.Account.Provider
+				return params.Token, nil
+			},
+			Session: func(params auth.SessionParams) (interface{}, error) {
+				return params.Token, nil
+			},
+		},
+	})
+	browser := newBrowser(app)
+	signIn(t, app, browser, provider)
+
+	res := request(t, browser, http.MethodGet, app.URL+"/auth/session")
+	var token map[string]interface{}
+	decode(t, res, &token)
+	if res.StatusCode != http.StatusOK || token["provider"] != "fake" || token["sub"] == "" {
+		t.Errorf("expected the claims of the callback in the token, got %d %v", res.StatusCode, token)
+	}
+}
+
+func TestCallbackRejectsProfileWithoutId(t *testing.T) {
+	provider := &fakeProvider{id: "fake", profile: auth.Profile{Email: "no-id@example.com"}}
+	app := newTestApp(t, auth.AuthServiceOptions{Providers: []auth.Provider{provider}})
+	browser := newBrowser(app)
+
+	res := signIn(t, app, browser, provider)
+	var body map[string]string
+	decode(t, res, &body)
+	if res.StatusCode != http.StatusBadGateway || body["error"] != string(auth.ErrorInvalidProfile) {
+		t.Errorf("expected a profile without id to be rejected, got %d %v", res.StatusCode, body)
+	}
+
+	if _, err := adapters.Memory().GetUserByEmail("no-id@example.com"); err == nil {
+		t.Errorf("expected no user to be created")
+	}
+}
+
+func TestSignInAgainAfterRevokingTokens(t *testing.T) {
+	provider := &fakeProvider{id: "fake", profile: auth.Profile{Id: "revoke-1"}}
+	service := newTestService(t, auth.AuthServiceOptions{Providers: []auth.Provider{provider}})
+	app := serve(t, service)
+	browser := newBrowser(app)
+
+	var user auth.User
+	decode(t, signIn(t, app, browser, provider), &user)
+
+	if res := request(t, browser, http.MethodPost, app.URL+"/auth/signout?revoke=true"); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected to sign out, got %d", res.StatusCode)
+	}
+	if _, err := service.GetProviderToken(context.Background(), user.Id, "fake"); !errors.Is(err, auth.ErrTokenExpired) {
+		t.Fatalf("expected the revoked token to be gone, got %v", err)
+	}
+
+	signIn(t, app, browser, provider)
+	if token, err := service.GetProviderToken(context.Background(), user.Id, "fake"); err != nil || token != "access-revoke-1" {
+		t.Errorf("expected the token of the new sign in, got %q (%v)", token, err)
+	}
+}
+
+// sessionToken returns the session cookie the browser holds for app.
+func sessionToken(t *testing.T, app *httptest.Server, browser *http.Client) string {
+	appURL, _ := url.Parse(app.URL)
+	for _, cookie := range browser.Jar.Cookies(appURL) {
+		if cookie.Name == "session" {
+			return cookie.Value
+		}
+	}
+	t.Fatalf("expected a session cookie")
+	return ""
+}
+
+func TestSignOut(t *testing.T) {
+	provider := &fakeProvider{id: "fake", profile: auth.Profile{Id: "signout-1"}}
+	app := newTestApp(t, auth.AuthServiceOptions{Providers: []auth.Provider{provider}})
+	browser := newBrowser(app)
+
+	signIn(t, app, browser, provider)
+	token := sessionToken(t, app, browser)
+
+	res := request(t, browser, http.MethodPost, app.URL+"/auth/signout?callbackUrl=/goodbye")
+	if res.StatusCode != http.StatusSeeOther || res.Header.Get(echo.HeaderLocation) != "/goodbye" {
+		t.Errorf("expected a redirect to /goodbye, got %d %s", res.StatusCode, res.Header.Get(echo.HeaderLocation))
+	}
+
+	var expired bool
+	for _, cookie := range res.Cookies() {
+		expired = expired || (cookie.Name == "session" && cookie.MaxAge < 0)
+	}
+	if !expired {
+		t.Errorf("expected the session cookie to be expired, got %v", res.Cookies())
+	}
+	if _, _, err := adapters.Memory().GetSessionAndUser(token); err == nil {
+		t.Errorf("expected the session to be deleted")
+	}
+	if res := request(t, browser, http.MethodGet, app.URL+"/auth/session"); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected to be signed out, got %d", res.StatusCode)
+	}
+}
+
+func TestSignOutRejectsForeignCallbackURL(t *testing.T) {
+	provider := &fakeProvider{id: "fake", profile: auth.Profile{Id: "signout-2"}}
+	app := newTestApp(t, auth.AuthServiceOptions{Providers: []auth.Provider{provider}})
+	browser := newBrowser(app)
+	signIn(t, app, browser, provider)
+
+	res := request(t, browser, http.MethodPost, app.URL+"/auth/signout?"+url.Values{"callbackUrl": {"https://evil.example/"}}.Encode())
+	if res.StatusCode != http.StatusOK || res.Header.Get(echo.HeaderLocation) != "" {
+		t.Errorf("expected no redirect to another site, got %d %s", res.StatusCode, res.Header.Get(echo.HeaderLocation))
+	}
+}
+
+func TestSignOutAll(t *testing.T) {
+	provider := &fakeProvider{id: "fake", profile: auth.Profile{Id: "signout-3"}}
+	app := newTestApp(t, auth.AuthServiceOptions{Providers: []auth.Provider{provider}})
+	laptop, phone := newBrowser(app), newBrowser(app)
+
+	signIn(t, app, laptop, provider)
+	signIn(t, app, phone, provider)
+	phoneToken := sessionToken(t, app, phone)
+
+	if res := request(t, laptop, http.MethodPost, app.URL+"/auth/signout/all"); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected to sign out everywhere, got %d", res.StatusCode)
+	}
+
+	if _, _, err := adapters.Memory().GetSessionAndUser(phoneToken); err == nil {
+		t.Errorf("expected the other session to be deleted")
+	}
+	for name, browser := range map[string]*http.Client{"laptop": laptop, "phone": phone} {
+		if res := request(t, browser, http.MethodGet, app.URL+"/auth/session"); res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("expected the %s to be signed out, got %d", name, res.StatusCode)
+		}
+	}
+}
+
+func TestSignOutAllRequiresDatabaseSessions(t *testing.T) {
+	provider := &fakeProvider{id: "fake", profile: auth.Profile{Id: "signout-4"}}
+	app := newTestApp(t, auth.AuthServiceOptions{
+		Providers: []auth.Provider{provider},
+		Session: auth.SessionOptions{
+			Strategy: auth.SessionStrategyJWT,
+			JWT:      auth.JWTOptions{Secret: "test-secret"},
+		},
+	})
+	browser := newBrowser(app)
+	signIn(t, app, browser, provider)
+
+	if res := request(t, browser, http.MethodPost, app.URL+"/auth/signout/all"); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected the jwt strategy to be refused, got %d", res.StatusCode)
+	}
+	if res := request(t, browser, http.MethodGet, app.URL+"/auth/session"); res.StatusCode != http.StatusOK {
+		t.Errorf("expected to stay signed in, got %d", res.StatusCode)
+	}
+}
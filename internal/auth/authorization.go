@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AssignRole gives a role to a user.
+func (s *Service) AssignRole(userId, role string) error {
+	return (*s.adapter).AddUserRole(userId, role)
+}
+
+// RevokeRole takes a role away from a user.
+func (s *Service) RevokeRole(userId, role string) error {
+	return (*s.adapter).RemoveUserRole(userId, role)
+}
+
+// GrantPermission adds a permission to every user holding role.
+func (s *Service) GrantPermission(role, permission string) error {
+	return (*s.adapter).AddRolePermission(role, permission)
+}
+
+// RevokePermission removes a permission from role.
+func (s *Service) RevokePermission(role, permission string) error {
+	return (*s.adapter).RemoveRolePermission(role, permission)
+}
+
+// GetRoles returns the roles of a user.
+func (s *Service) GetRoles(userId string) ([]string, error) {
+	return (*s.adapter).GetUserRoles(userId)
+}
+
+// GetPermissions returns the permissions a user holds through their roles.
+func (s *Service) GetPermissions(userId string) ([]string, error) {
+	roles, err := (*s.adapter).GetUserRoles(userId)
+	if err != nil {
+		return nil, err
+	}
+	return (*s.adapter).GetRolePermissions(roles)
+}
+
+// RequireRole lets a request through when the user holds any of roles.
+func (s *Service) RequireRole(roles ...string) echo.MiddlewareFunc {
+	return s.requireAuthorization(func(user User) bool {
+		return slices.ContainsFunc(roles, func(role string) bool {
+			return slices.Contains(user.Roles, role)
+		})
+	})
+}
+
+// RequirePermission lets a request through when the user holds all of permissions.
+func (s *Service) RequirePermission(permissions ...string) echo.MiddlewareFunc {
+	return s.requireAuthorization(func(user User) bool {
+		for _, permission := range permissions {
+			if !slices.Contains(user.Permissions, permission) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func (s *Service) requireAuthorization(allowed func(user User) bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if _, _, err := s.resolveSession(c); err != nil {
+				return s.unauthorized(c)
+			}
+
+			user, err := s.resolveAuthorization(c)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{
+					"error": err.Error(),
+				})
+			}
+
+			if !allowed(user) {
+				return c.JSON(http.StatusForbidden, map[string]string{
+					"error": "forbidden",
+				})
+			}
+			return next(c)
+		}
+	}
+}
+
+// resolveAuthorization loads the roles and permissions of the resolved user
+// at most once per request.
+func (s *Service) resolveAuthorization(c echo.Context) (User, error) {
+	if _, _, err := s.resolveSession(c); err != nil {
+		return User{}, err
+	}
+
+	r := c.Get(sessionContextKey).(*resolvedSession)
+	if r.authorized || *s.adapter == nil {
+		return r.user, nil
+	}
+
+	roles, err := (*s.adapter).GetUserRoles(r.user.Id)
+	if err != nil {
+		return User{}, err
+	}
+	permissions, err := (*s.adapter).GetRolePermissions(roles)
+	if err != nil {
+		return User{}, err
+	}
+
+	r.user.Roles = roles
+	r.user.Permissions = permissions
+	r.authorized = true
+
+	return r.user, nil
+}
@@ -0,0 +1,108 @@
+package auth_test
+
+import (
+	"echo-server/internal/auth"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// newAuthorizationApp serves /admin to the authz-admin role and /users to
+// holders of both user permissions.
+func newAuthorizationApp(t *testing.T, provider *fakeProvider) (*auth.Service, *httptest.Server) {
+	service := newTestService(t, auth.AuthServiceOptions{Providers: []auth.Provider{provider}})
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusNoContent) }
+	app := serve(t, service, func(e *echo.Echo) {
+		e.GET("/admin", ok, service.RequireRole("authz-admin", "authz-owner"))
+		e.GET("/users", ok, service.RequirePermission("authz:users:read", "authz:users:write"))
+	})
+	return service, app
+}
+
+func TestRequireRole(t *testing.T) {
+	provider := &fakeProvider{id: "fake", profile: auth.Profile{Id: "authz-1"}}
+	service, app := newAuthorizationApp(t, provider)
+
+	res := request(t, newBrowser(app), http.MethodGet, app.URL+"/admin")
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a session, got %d", res.StatusCode)
+	}
+
+	browser := newBrowser(app)
+	var user auth.User
+	decode(t, signIn(t, app, browser, provider), &user)
+
+	res = request(t, browser, http.MethodGet, app.URL+"/admin")
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 without the role, got %d", res.StatusCode)
+	}
+
+	if err := service.AssignRole(user.Id, "authz-owner"); err != nil {
+		t.Fatalf("AssignRole() error = %v", err)
+	}
+	res = request(t, browser, http.MethodGet, app.URL+"/admin")
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("expected any of the roles to pass, got %d", res.StatusCode)
+	}
+
+	service.RevokeRole(user.Id, "authz-owner")
+	res = request(t, browser, http.MethodGet, app.URL+"/admin")
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 once the role is revoked, got %d", res.StatusCode)
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	provider := &fakeProvider{id: "fake", profile: auth.Profile{Id: "authz-2"}}
+	service, app := newAuthorizationApp(t, provider)
+
+	res := request(t, newBrowser(app), http.MethodGet, app.URL+"/users")
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a session, got %d", res.StatusCode)
+	}
+
+	browser := newBrowser(app)
+	var user auth.User
+	decode(t, signIn(t, app, browser, provider), &user)
+
+	service.AssignRole(user.Id, "authz-reader")
+	service.GrantPermission("authz-reader", "authz:users:read")
+	res = request(t, browser, http.MethodGet, app.URL+"/users")
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 with only some of the permissions, got %d", res.StatusCode)
+	}
+
+	service.AssignRole(user.Id, "authz-writer")
+	service.GrantPermission("authz-writer", "authz:users:write")
+	res = request(t, browser, http.MethodGet, app.URL+"/users")
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("expected all of the permissions to pass, got %d", res.StatusCode)
+	}
+}
+
+func TestSessionIncludesRoles(t *testing.T) {
+	provider := &fakeProvider{id: "fake", profile: auth.Profile{Id: "authz-3"}}
+	service, app := newAuthorizationApp(t, provider)
+	browser := newBrowser(app)
+
+	var user auth.User
+	decode(t, signIn(t, app, browser, provider), &user)
+	service.AssignRole(user.Id, "authz-auditor")
+	service.GrantPermission("authz-auditor", "authz:logs:read")
+
+	res := request(t, browser, http.MethodGet, app.URL+"/auth/session")
+	var session auth.User
+	decode(t, res, &session)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected a session, got %d", res.StatusCode)
+	}
+	if !reflect.DeepEqual(session.Roles, []string{"authz-auditor"}) {
+		t.Errorf("expected roles [authz-auditor], got %v", session.Roles)
+	}
+	if !reflect.DeepEqual(session.Permissions, []string{"authz:logs:read"}) {
+		t.Errorf("expected permissions [authz:logs:read], got %v", session.Permissions)
+	}
+}
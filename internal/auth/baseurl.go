@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// baseURL returns the public URL of the server, without a trailing slash.
+// The configured BaseURL wins. Otherwise it is derived from the request,
+// honoring X-Forwarded-Proto and X-Forwarded-Host only behind a trusted proxy.
+func (s *Service) baseURL(c echo.Context) string {
+	if s.publicURL != "" {
+		return s.publicURL
+	}
+
+	req := c.Request()
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	host := req.Host
+
+	if s.trustProxy {
+		if proto := forwardedValue(req.Header.Get(echo.HeaderXForwardedProto)); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwardedHost := forwardedValue(req.Header.Get("X-Forwarded-Host")); forwardedHost != "" {
+			host = forwardedHost
+		}
+	}
+
+	return fmt.Sprintf("%s://%s", scheme, host)
+}
+
+// callbackURL returns the redirect_uri registered for a provider.
+func (s *Service) callbackURL(c echo.Context, providerId string) string {
+	return s.baseURL(c) + "/auth/callback/" + providerId
+}
+
+// clientIP returns the address of the client. X-Forwarded-For is only
+// honored behind a trusted proxy, as any client can send it. The proxy
+// appends the address it saw, so its last entry is the one to trust.
+func (s *Service) clientIP(c echo.Context) string {
+	req := c.Request()
+
+	if s.trustProxy {
+		header := req.Header.Get(echo.HeaderXForwardedFor)
+		if ip := strings.TrimSpace(header[strings.LastIndex(header, ",")+1:]); ip != "" {
+			return ip
+		}
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// forwardedValue returns the value set by the proxy closest to the client
+// when the header was appended to by a chain of proxies.
+func forwardedValue(header string) string {
+	first, _, _ := strings.Cut(header, ",")
+	return strings.TrimSpace(first)
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestBaseURL(t *testing.T) {
+	e := echo.New()
+	context := func() echo.Context {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = "internal:8080"
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("X-Forwarded-Host", "example.com, proxy.local")
+		return e.NewContext(req, httptest.NewRecorder())
+	}
+
+	tests := []struct {
+		name    string
+		service Service
+		want    string
+	}{
+		{"configured", Service{publicURL: "https://auth.example.com"}, "https://auth.example.com"},
+		{"request", Service{}, "http://internal:8080"},
+		{"trusted proxy", Service{trustProxy: true}, "https://example.com"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.service.baseURL(context()); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	e := echo.New()
+	context := func(forwardedFor string) echo.Context {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "10.0.0.1:4321"
+		if forwardedFor != "" {
+			req.Header.Set(echo.HeaderXForwardedFor, forwardedFor)
+		}
+		return e.NewContext(req, httptest.NewRecorder())
+	}
+
+	tests := []struct {
+		name         string
+		service      Service
+		forwardedFor string
+		want         string
+	}{
+		{"direct", Service{}, "", "10.0.0.1"},
+		{"spoofed", Service{}, "203.0.113.7", "10.0.0.1"},
+		{"trusted proxy", Service{trustProxy: true}, "203.0.113.7, 198.51.100.2", "198.51.100.2"},
+		{"trusted proxy without header", Service{trustProxy: true}, "", "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.service.clientIP(context(tt.forwardedFor)); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
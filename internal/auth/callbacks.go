@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+)
+
+// Callbacks let the app hook into the sign in lifecycle. Every callback is optional.
+type Callbacks struct {
+	// SignIn runs after the provider has authenticated the user and before a
+	// session is created. Returning an error denies the sign in, returning a
+	// non-empty URL redirects the browser there instead of signing in.
+	SignIn func(params SignInParams) (string, error)
+	// Redirect decides where the browser is sent after signing in or out.
+	// It receives the requested URL and the base URL of the server.
+	Redirect func(url, baseUrl string) string
+	// Session shapes the response of the session endpoint.
+	Session func(params SessionParams) (interface{}, error)
+	// JWT customizes the payload of the session token when the jwt strategy
+	// is used. It runs when the token is first issued.
+	JWT func(params JWTParams) (jwt.MapClaims, error)
+}
+
+type SignInParams struct {
+	User    User
+	Account Account
+	Profile Profile
+}
+
+type SessionParams struct {
+	Session Session
+	User    User
+	// Token holds the decoded session token when the jwt strategy is used.
+	Token jwt.MapClaims
+}
+
+type JWTParams struct {
+	Token   jwt.MapClaims
+	User    User
+	Account Account
+	Profile Profile
+}
+
+// redirectURL returns where to send the browser for a requested target. An
+// empty result means there is nowhere to redirect to.
+func (s *Service) redirectURL(c echo.Context, target string) string {
+	if s.callbacks.Redirect != nil {
+		return s.callbacks.Redirect(target, s.baseURL(c))
+	}
+
+	if s.isAllowedRedirect(c, target) {
+		return target
+	}
+	return ""
+}
+
+// isAllowedRedirect accepts paths on this server and absolute URLs on the
+// same origin or on one of the allowed redirect hosts.
+func (s *Service) isAllowedRedirect(c echo.Context, target string) bool {
+	if isLocalURL(target) {
+		return true
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || strings.ContainsRune(target, '\\') || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return false
+	}
+
+	if base, err := url.Parse(s.baseURL(c)); err == nil && u.Scheme == base.Scheme && strings.EqualFold(u.Host, base.Host) {
+		return true
+	}
+
+	for _, host := range s.redirectHosts {
+		if strings.EqualFold(u.Host, host) {
+			return true
+		}
+	}
+	return false
+}
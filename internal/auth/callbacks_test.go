@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestRedirectURL(t *testing.T) {
+	s := Service{publicURL: "https://example.com", redirectHosts: []string{"app.example.com"}}
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"/dashboard?tab=1", true},
+		{"https://example.com/dashboard", true},
+		{"https://APP.example.com/", true},
+		{"http://example.com/", false},
+		{"https://evil.example/", false},
+		{"//evil.example/", false},
+		{"/\\evil.example/", false},
+		{"/\t/evil.example", false},
+		{"/\n/evil.example", false},
+		{"/\r\n/evil.example", false},
+		{"/x\\/evil.example", false},
+		// Still encoded, the browser reads it as a path.
+		{"/%09/evil.example", true},
+		{"https://example.com\\@evil.example/", false},
+		{"https://example.com\t/", false},
+		{"https://example.com@evil.example/", false},
+		{"javascript:alert(1)", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := s.redirectURL(c, tt.target); (got != "") != tt.want {
+			t.Errorf("%q: expected allowed=%v, got %q", tt.target, tt.want, got)
+		}
+	}
+}
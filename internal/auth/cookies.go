@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	sessionCookieName = "session"
+	pkceCookieName    = "auth.pkce"
+	stateCookieName   = "auth.state"
+	nonceCookieName   = "auth.nonce"
+)
+
+// getSessionToken returns the session token of the request, from the
+// session cookie of browsers or the bearer token of clients such as a CLI
+// signed in with the device flow, and whether it came from the cookie.
+func getSessionToken(c echo.Context) (string, bool) {
+	if cookie, err := c.Cookie(sessionCookieName); err == nil {
+		return cookie.Value, true
+	}
+
+	if token, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer "); ok {
+		return strings.TrimSpace(token), false
+	}
+	return "", false
+}
+
+// setSessionCookie stores the session token. The cookie is SameSite=Lax, as
+// a Strict cookie is not sent on the landing page of a sign in that arrives
+// through the cross-site redirects of a provider.
+func setSessionCookie(c echo.Context, session Session) {
+	c.SetCookie(&http.Cookie{
+		Name:     sessionCookieName,
+		Value:    session.SessionToken,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		Expires:  session.Expires,
+	})
+}
+
+func clearSessionCookie(c echo.Context) {
+	c.SetCookie(&http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+	})
+}
+
+// setSignedCookie stores value in a cookie that is signed with AUTH_SECRET and
+// stops being accepted after maxAge. It carries state across the redirect to
+// a provider, so it is sent on the top-level navigation back from it, and
+// with crossSitePost also on a form post back from it.
+func setSignedCookie(c echo.Context, name, value string, maxAge time.Duration, crossSitePost bool) {
+	sameSite := http.SameSiteLaxMode
+	if crossSitePost {
+		sameSite = http.SameSiteNoneMode
+	}
+
+	expires := time.Now().Add(maxAge)
+	payload := fmt.Sprintf("%s|%d", base64.RawURLEncoding.EncodeToString([]byte(value)), expires.Unix())
+
+	c.SetCookie(&http.Cookie{
+		Name:     name,
+		Value:    payload + "|" + createHMACHash(name+"|"+payload),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: sameSite,
+		Expires:  expires,
+	})
+}
+
+// readSignedCookie returns the value of a cookie set by setSignedCookie.
+func readSignedCookie(c echo.Context, name string) (string, error) {
+	cookie, err := c.Cookie(name)
+	if err != nil {
+		return "", fmt.Errorf("missing %s cookie", name)
+	}
+
+	parts := strings.Split(cookie.Value, "|")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed %s cookie", name)
+	}
+
+	payload := parts[0] + "|" + parts[1]
+	if !hmac.Equal([]byte(createHMACHash(name+"|"+payload)), []byte(parts[2])) {
+		return "", fmt.Errorf("invalid %s cookie", name)
+	}
+
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "", fmt.Errorf("expired %s cookie", name)
+	}
+
+	value, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("malformed %s cookie", name)
+	}
+
+	return string(value), nil
+}
+
+func clearCookie(c echo.Context, name string) {
+	c.SetCookie(&http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+	})
+}
+
+// clearSignInCookies removes the cookies Login and Link set for the callback.
+func clearSignInCookies(c echo.Context) {
+	for _, name := range []string{stateCookieName, pkceCookieName, nonceCookieName} {
+		clearCookie(c, name)
+	}
+}
+
+// isLocalURL reports whether target is a path on this server, rejecting
+// scheme-relative URLs such as "//evil.example". Browsers drop tabs and
+// newlines and read backslashes as slashes, so "/\t/evil.example" and
+// "/\\evil.example" are rejected as well.
+func isLocalURL(target string) bool {
+	if strings.ContainsRune(target, '\\') || strings.ContainsFunc(target, unicode.IsControl) {
+		return false
+	}
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") {
+		return false
+	}
+
+	u, err := url.Parse(target)
+	return err == nil && u.Scheme == "" && u.Host == "" && u.User == nil
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestSignedCookie(t *testing.T) {
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	setSignedCookie(e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec), "test", "a|value", time.Minute, false)
+	cookie := rec.Result().Cookies()[0]
+
+	read := func(cookie *http.Cookie) (string, error) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(cookie)
+		return readSignedCookie(e.NewContext(req, httptest.NewRecorder()), "test")
+	}
+
+	if value, err := read(cookie); err != nil || value != "a|value" {
+		t.Errorf("Expected a|value, got %q (%v)", value, err)
+	}
+
+	tampered := *cookie
+	tampered.Value = "dGFtcGVyZWQ" + tampered.Value[len("YXx2YWx1ZQ"):]
+	if _, err := read(&tampered); err == nil {
+		t.Errorf("Expected tampered cookie to fail verification")
+	}
+
+	renamed := *cookie
+	renamed.Name = "other"
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&renamed)
+	if _, err := readSignedCookie(e.NewContext(req, httptest.NewRecorder()), "other"); err == nil {
+		t.Errorf("Expected cookie signed for another name to fail verification")
+	}
+}
+
+func TestSessionCookieIsLax(t *testing.T) {
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	setSessionCookie(e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec), Session{SessionToken: "token", Expires: time.Now().Add(time.Hour)})
+	cookie := rec.Result().Cookies()[0]
+
+	if cookie.SameSite != http.SameSiteLaxMode || !cookie.Secure || !cookie.HttpOnly {
+		t.Errorf("Expected a Secure, HttpOnly, SameSite=Lax cookie, got %+v", cookie)
+	}
+}
@@ -0,0 +1,324 @@
+package auth
+
+import (
+	"crypto/rand"
+	"html/template"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// deviceCodeMaxAge is how long the user has to enter the user code.
+	deviceCodeMaxAge = 15 * time.Minute
+	// devicePollInterval is the minimum wait between two token requests of a client.
+	devicePollInterval = 5 * time.Second
+	deviceGrantType    = "urn:ietf:params:oauth:grant-type:device_code"
+	// userCodeAlphabet has no vowels, so user codes cannot spell words, and
+	// no characters that are easily confused.
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+	// maxUserCodeAttempts is how many invalid user codes a user may enter
+	// within deviceCodeMaxAge, so user codes cannot be guessed (RFC 8628 5.1).
+	maxUserCodeAttempts = 5
+)
+
+// deviceAuthorization is a pending sign in of a device, as described by RFC 8628.
+type deviceAuthorization struct {
+	clientId string
+	userCode string
+	expires  time.Time
+	interval time.Duration
+	lastPoll time.Time
+	// userId is set when a signed in user approves the device.
+	userId string
+	denied bool
+}
+
+// userCodeFailures counts the invalid user codes entered by a user.
+type userCodeFailures struct {
+	count   int
+	expires time.Time
+}
+
+// deviceStore keeps the pending device authorizations in memory, so a
+// device must poll the instance that issued its code.
+type deviceStore struct {
+	mu           sync.Mutex
+	byDeviceCode map[string]*deviceAuthorization
+	byUserCode   map[string]string
+	// failures is keyed by user id rather than session, so signing in
+	// again or a renewed session token does not reset the count.
+	failures map[string]*userCodeFailures
+}
+
+func newDeviceStore() *deviceStore {
+	return &deviceStore{
+		byDeviceCode: map[string]*deviceAuthorization{},
+		byUserCode:   map[string]string{},
+		failures:     map[string]*userCodeFailures{},
+	}
+}
+
+// deleteLocked forgets a device authorization. The caller holds mu.
+func (d *deviceStore) deleteLocked(deviceCode string) {
+	if auth, ok := d.byDeviceCode[deviceCode]; ok {
+		delete(d.byUserCode, auth.userCode)
+		delete(d.byDeviceCode, deviceCode)
+	}
+}
+
+// DeviceCode starts the device flow: it issues the device code the client
+// polls with and the user code the user enters at the verification page.
+func (s *Service) DeviceCode(c echo.Context) error {
+	clientId := c.FormValue("client_id")
+	if clientId == "" || !slices.Contains(s.deviceClients, clientId) {
+		return oauthError(c, http.StatusUnauthorized, "unauthorized_client", "the client may not use the device flow")
+	}
+
+	now := time.Now()
+	deviceCode := generateRandomString(64)
+	auth := &deviceAuthorization{
+		clientId: clientId,
+		expires:  now.Add(deviceCodeMaxAge),
+		interval: devicePollInterval,
+	}
+
+	s.devices.mu.Lock()
+	for code, pending := range s.devices.byDeviceCode {
+		if now.After(pending.expires) {
+			s.devices.deleteLocked(code)
+		}
+	}
+	for userId, failures := range s.devices.failures {
+		if now.After(failures.expires) {
+			delete(s.devices.failures, userId)
+		}
+	}
+	for {
+		auth.userCode = generateUserCode()
+		if _, taken := s.devices.byUserCode[auth.userCode]; !taken {
+			break
+		}
+	}
+	s.devices.byDeviceCode[deviceCode] = auth
+	s.devices.byUserCode[auth.userCode] = deviceCode
+	s.devices.mu.Unlock()
+
+	verificationURI := s.baseURL(c) + "/auth/device"
+	userCode := formatUserCode(auth.userCode)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"device_code":               deviceCode,
+		"user_code":                 userCode,
+		"verification_uri":          verificationURI,
+		"verification_uri_complete": verificationURI + "?user_code=" + userCode,
+		"expires_in":                int(deviceCodeMaxAge.Seconds()),
+		"interval":                  int(devicePollInterval.Seconds()),
+	})
+}
+
+var devicePage = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in a device</title></head>
+<body>
+<h1>Sign in a device</h1>
+{{if .Message}}<p>{{.Message}}</p>
+{{end}}{{if .Form}}<p>Signed in as {{.User}}. Enter the code shown on your device.</p>
+<form method="post">
+<input name="user_code" value="{{.UserCode}}" autocomplete="off" autofocus>
+<button name="action" value="approve">Approve</button>
+<button name="action" value="deny">Deny</button>
+</form>
+{{end}}</body>
+</html>
+`))
+
+// Device shows the verification page, where a signed in user enters the
+// user code of a device. The session cookie is SameSite=Lax, so it is not
+// sent when another site posts the form.
+func (s *Service) Device(c echo.Context) error {
+	_, user, err := s.resolveSession(c)
+	if err != nil {
+		return s.unauthorized(c)
+	}
+
+	return s.renderDevicePage(c, http.StatusOK, user, "", c.QueryParam("user_code"))
+}
+
+// VerifyDevice approves or denies the device with the entered user code.
+// A user who entered maxUserCodeAttempts invalid codes is turned away.
+func (s *Service) VerifyDevice(c echo.Context) error {
+	_, user, err := s.resolveSession(c)
+	if err != nil {
+		return s.unauthorized(c)
+	}
+
+	userCode := normalizeUserCode(c.FormValue("user_code"))
+	now := time.Now()
+
+	s.devices.mu.Lock()
+	failures := s.devices.failures[user.Id]
+	if failures != nil && now.After(failures.expires) {
+		delete(s.devices.failures, user.Id)
+		failures = nil
+	}
+	if failures != nil && failures.count >= maxUserCodeAttempts {
+		s.devices.mu.Unlock()
+		return s.renderDevicePage(c, http.StatusTooManyRequests, User{}, "Too many invalid codes. Please try again later.", "")
+	}
+
+	auth, ok := s.devices.byDeviceCode[s.devices.byUserCode[userCode]]
+	if ok && (now.After(auth.expires) || auth.userId != "" || auth.denied) {
+		ok = false
+	}
+	if ok {
+		if c.FormValue("action") == "deny" {
+			auth.denied = true
+		} else {
+			auth.userId = user.Id
+		}
+	} else {
+		if failures == nil {
+			failures = &userCodeFailures{expires: now.Add(deviceCodeMaxAge)}
+			s.devices.failures[user.Id] = failures
+		}
+		failures.count++
+	}
+	s.devices.mu.Unlock()
+
+	if !ok {
+		return s.renderDevicePage(c, http.StatusBadRequest, user, "The code is invalid or has expired.", c.FormValue("user_code"))
+	}
+	if c.FormValue("action") == "deny" {
+		return s.renderDevicePage(c, http.StatusOK, User{}, "The device was denied access.", "")
+	}
+	return s.renderDevicePage(c, http.StatusOK, User{}, "The device is signed in. You can close this page.", "")
+}
+
+func (s *Service) renderDevicePage(c echo.Context, status int, user User, message, userCode string) error {
+	if !acceptsHTML(c.Request()) {
+		if status != http.StatusOK {
+			return c.JSON(status, map[string]string{"error": message})
+		}
+		return c.JSON(status, map[string]string{"message": message})
+	}
+
+	name := user.Email
+	if name == "" {
+		name = user.Name
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	c.Response().WriteHeader(status)
+	return devicePage.Execute(c.Response(), map[string]interface{}{
+		"Form":     user.Id != "",
+		"User":     name,
+		"Message":  message,
+		"UserCode": userCode,
+	})
+}
+
+// DeviceToken is polled by the client with its device code. Once the user
+// approved the device it answers with a session token, which the client
+// sends as a bearer token.
+func (s *Service) DeviceToken(c echo.Context) error {
+	if c.FormValue("grant_type") != deviceGrantType {
+		return oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "")
+	}
+
+	deviceCode := c.FormValue("device_code")
+	now := time.Now()
+
+	s.devices.mu.Lock()
+	auth, ok := s.devices.byDeviceCode[deviceCode]
+	if !ok || auth.clientId != c.FormValue("client_id") {
+		s.devices.mu.Unlock()
+		return oauthError(c, http.StatusBadRequest, "invalid_grant", "unknown device_code")
+	}
+
+	var errorCode string
+	switch {
+	case now.After(auth.expires):
+		errorCode = "expired_token"
+		s.devices.deleteLocked(deviceCode)
+	case auth.denied:
+		errorCode = "access_denied"
+		s.devices.deleteLocked(deviceCode)
+	case auth.userId != "":
+		s.devices.deleteLocked(deviceCode)
+	case now.Sub(auth.lastPoll) < auth.interval:
+		// Clients that poll too fast have to slow down for good.
+		errorCode = "slow_down"
+		auth.interval += devicePollInterval
+		auth.lastPoll = now
+	default:
+		errorCode = "authorization_pending"
+		auth.lastPoll = now
+	}
+	userId := auth.userId
+	s.devices.mu.Unlock()
+
+	if errorCode != "" {
+		return oauthError(c, http.StatusBadRequest, errorCode, "")
+	}
+
+	user, err := (*s.adapter).GetUserById(userId)
+	if err != nil {
+		return oauthError(c, http.StatusInternalServerError, "server_error", "")
+	}
+
+	session, err := s.issueSession(c, SignInParams{User: user})
+	if err != nil {
+		return oauthError(c, http.StatusInternalServerError, "server_error", "")
+	}
+	s.publish(c, Event{Type: EventSignIn, User: user})
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"access_token": session.SessionToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(time.Until(session.Expires).Seconds()),
+	})
+}
+
+// oauthError answers with an error response of RFC 6749.
+func oauthError(c echo.Context, status int, code, description string) error {
+	body := map[string]string{"error": code}
+	if description != "" {
+		body["error_description"] = description
+	}
+	return c.JSON(status, body)
+}
+
+func generateUserCode() string {
+	code := make([]byte, userCodeLength)
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code)
+}
+
+// formatUserCode splits a user code in two halves for readability.
+func formatUserCode(code string) string {
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+// normalizeUserCode accepts codes typed in lower case or without the dash.
+func normalizeUserCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+}
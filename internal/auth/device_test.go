@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// deviceAdapter knows a single user. The methods it does not override are
+// not used by the device flow under the jwt strategy.
+type deviceAdapter struct {
+	Adapter
+	user User
+}
+
+func (a deviceAdapter) GetUserById(id string) (User, error) {
+	return a.user, nil
+}
+
+func TestDeviceFlow(t *testing.T) {
+	user := User{Id: "1", Name: "Test", Email: "test@example.com"}
+	s := New(AuthServiceOptions{
+		Adapter: deviceAdapter{user: user},
+		Session: SessionOptions{
+			Strategy: SessionStrategyJWT,
+			JWT:      JWTOptions{Secret: "test-secret"},
+		},
+		DeviceClients: []string{"cli"},
+	})
+	e := echo.New()
+
+	post := func(handler echo.HandlerFunc, form url.Values, cookie *http.Cookie) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		if err := handler(e.NewContext(req, rec)); err != nil {
+			t.Fatalf("handler error = %v", err)
+		}
+		var body map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec, body
+	}
+
+	if rec, _ := post(s.DeviceCode, url.Values{"client_id": {"unknown"}}, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected unknown clients to be rejected, got %d", rec.Code)
+	}
+
+	_, code := post(s.DeviceCode, url.Values{"client_id": {"cli"}}, nil)
+	deviceCode, _ := code["device_code"].(string)
+	userCode, _ := code["user_code"].(string)
+	if deviceCode == "" || len(userCode) != userCodeLength+1 {
+		t.Fatalf("expected a device and user code, got %v", code)
+	}
+
+	poll := url.Values{"grant_type": {deviceGrantType}, "client_id": {"cli"}, "device_code": {deviceCode}}
+	if _, body := post(s.DeviceToken, poll, nil); body["error"] != "authorization_pending" {
+		t.Errorf("expected authorization_pending, got %v", body)
+	}
+	if _, body := post(s.DeviceToken, poll, nil); body["error"] != "slow_down" {
+		t.Errorf("expected slow_down when polling too fast, got %v", body)
+	}
+
+	// Users may type the code in lower case and without the dash.
+	typed := strings.ToLower(strings.ReplaceAll(userCode, "-", ""))
+	if rec, _ := post(s.VerifyDevice, url.Values{"user_code": {typed}, "action": {"approve"}}, sessionCookie(t, s, user)); rec.Code != http.StatusOK {
+		t.Fatalf("expected the device to be approved, got %d %s", rec.Code, rec.Body)
+	}
+
+	s.devices.byDeviceCode[deviceCode].lastPoll = time.Time{}
+	rec, token := post(s.DeviceToken, poll, nil)
+	accessToken, _ := token["access_token"].(string)
+	if rec.Code != http.StatusOK || accessToken == "" || token["token_type"] != "Bearer" {
+		t.Fatalf("expected a session token, got %d %v", rec.Code, token)
+	}
+
+	if _, body := post(s.DeviceToken, poll, nil); body["error"] != "invalid_grant" {
+		t.Errorf("expected the device code to be single use, got %v", body)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+accessToken)
+	signedIn, _, err := s.resolveSession(e.NewContext(req, httptest.NewRecorder()))
+	if err != nil || signedIn.UserId != user.Id {
+		t.Errorf("expected the token to authenticate the user, got %+v (%v)", signedIn, err)
+	}
+}
+
+func TestDeviceFlowDenied(t *testing.T) {
+	user := User{Id: "1", Email: "test@example.com"}
+	s := New(AuthServiceOptions{
+		Session: SessionOptions{
+			Strategy: SessionStrategyJWT,
+			JWT:      JWTOptions{Secret: "test-secret"},
+		},
+		DeviceClients: []string{"cli"},
+	})
+	e := echo.New()
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("client_id=cli"))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec := httptest.NewRecorder()
+	s.DeviceCode(e.NewContext(req, rec))
+	var code map[string]string
+	json.Unmarshal(rec.Body.Bytes(), &code)
+
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/auth/device?user_code="+code["user_code"], nil), httptest.NewRecorder())
+	if err := s.Device(c); err != nil || c.Response().Status != http.StatusUnauthorized {
+		t.Errorf("expected the verification page to require a session, got %d", c.Response().Status)
+	}
+
+	form := url.Values{"user_code": {code["user_code"]}, "action": {"deny"}}
+	req = httptest.NewRequest(http.MethodPost, "/auth/device", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	req.AddCookie(sessionCookie(t, s, user))
+	if err := s.VerifyDevice(e.NewContext(req, httptest.NewRecorder())); err != nil {
+		t.Fatalf("VerifyDevice() error = %v", err)
+	}
+
+	poll := url.Values{"grant_type": {deviceGrantType}, "client_id": {"cli"}, "device_code": {code["device_code"]}}
+	req = httptest.NewRequest(http.MethodPost, "/auth/device/token", strings.NewReader(poll.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec = httptest.NewRecorder()
+	s.DeviceToken(e.NewContext(req, rec))
+	if !strings.Contains(rec.Body.String(), `"access_denied"`) {
+		t.Errorf("expected access_denied, got %s", rec.Body)
+	}
+}
+
+func TestVerifyDeviceAttemptLimit(t *testing.T) {
+	user := User{Id: "1", Email: "test@example.com"}
+	s := New(AuthServiceOptions{
+		Session: SessionOptions{
+			Strategy: SessionStrategyJWT,
+			JWT:      JWTOptions{Secret: "test-secret"},
+		},
+		DeviceClients: []string{"cli"},
+	})
+	e := echo.New()
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("client_id=cli"))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec := httptest.NewRecorder()
+	s.DeviceCode(e.NewContext(req, rec))
+	var code map[string]string
+	json.Unmarshal(rec.Body.Bytes(), &code)
+
+	verify := func(userCode string, cookie *http.Cookie) int {
+		form := url.Values{"user_code": {userCode}, "action": {"approve"}}
+		req := httptest.NewRequest(http.MethodPost, "/auth/device", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		if err := s.VerifyDevice(e.NewContext(req, rec)); err != nil {
+			t.Fatalf("VerifyDevice() error = %v", err)
+		}
+		return rec.Code
+	}
+
+	for i := 0; i < maxUserCodeAttempts; i++ {
+		if status := verify("BBBB-BBBB", sessionCookie(t, s, user)); status != http.StatusBadRequest {
+			t.Fatalf("expected an invalid code to be rejected, got %d", status)
+		}
+	}
+	if status := verify(code["user_code"], sessionCookie(t, s, user)); status != http.StatusTooManyRequests {
+		t.Errorf("expected the user to be locked out after %d invalid codes, got %d", maxUserCodeAttempts, status)
+	}
+
+	if status := verify(code["user_code"], sessionCookie(t, s, User{Id: "2"})); status != http.StatusOK {
+		t.Errorf("expected another user to approve the device, got %d", status)
+	}
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ErrorCode identifies why signing in failed. It is the error query
+// parameter of the error page.
+type ErrorCode string
+
+const (
+	// ErrorAccessDenied means the user declined at the provider, or the
+	// SignIn callback denied the sign in.
+	ErrorAccessDenied ErrorCode = "AccessDenied"
+	// ErrorInvalidState means the callback did not match a sign in started
+	// by this browser, e.g. it expired or was replayed.
+	ErrorInvalidState ErrorCode = "InvalidState"
+	// ErrorUnknownProvider means the callback names a provider that is not configured.
+	ErrorUnknownProvider ErrorCode = "UnknownProvider"
+	// ErrorProvider means the provider returned an error other than access denied.
+	ErrorProvider ErrorCode = "ProviderError"
+	// ErrorExchangeFailed means the authorization code could not be exchanged for tokens.
+	ErrorExchangeFailed ErrorCode = "ExchangeFailed"
+	// ErrorInvalidProfile means the id_token or the profile of the user
+	// could not be verified or read.
+	ErrorInvalidProfile ErrorCode = "InvalidProfile"
+	// ErrorAccountNotLinked means the email belongs to a user who signed in
+	// with another provider. See ErrAccountNotLinked.
+	ErrorAccountNotLinked ErrorCode = "AccountNotLinked"
+	// ErrorAccountInUse means the account is linked to another user. See ErrAccountInUse.
+	ErrorAccountInUse ErrorCode = "AccountInUse"
+	// ErrorProviderLinked means the user already has an account of the
+	// provider. See ErrProviderLinked.
+	ErrorProviderLinked ErrorCode = "ProviderLinked"
+	// ErrorInternal means the server failed, e.g. the adapter.
+	ErrorInternal ErrorCode = "Internal"
+)
+
+// errorDescriptions are shown to the user. They never include the
+// underlying error, which may contain details of the server.
+var errorDescriptions = map[ErrorCode]string{
+	ErrorAccessDenied:     "Access was denied.",
+	ErrorInvalidState:     "The sign in link has expired or was already used. Please try again.",
+	ErrorUnknownProvider:  "This sign in method is not available.",
+	ErrorProvider:         "The sign in provider reported an error. Please try again.",
+	ErrorExchangeFailed:   "The sign in provider could not be reached. Please try again.",
+	ErrorInvalidProfile:   "The sign in provider returned an invalid response.",
+	ErrorAccountNotLinked: "This email is already used by another sign in method. Sign in with it first to link this account.",
+	ErrorAccountInUse:     "This account is already linked to another user.",
+	ErrorProviderLinked:   "Another account of this sign in method is already linked. Unlink it first.",
+	ErrorInternal:         "Something went wrong. Please try again later.",
+}
+
+// SignInError is a failed sign in, classified by its code.
+type SignInError struct {
+	Code ErrorCode
+	Err  error
+}
+
+// NewSignInError classifies err with code.
+func NewSignInError(code ErrorCode, err error) *SignInError {
+	return &SignInError{Code: code, Err: err}
+}
+
+func (e *SignInError) Error() string {
+	if e.Err == nil {
+		return string(e.Code)
+	}
+	return string(e.Code) + ": " + e.Err.Error()
+}
+
+func (e *SignInError) Unwrap() error {
+	return e.Err
+}
+
+// Status returns the HTTP status of the error: 4xx when the user or the
+// request is at fault, 502 when the provider is, and 500 when the server is.
+func (e *SignInError) Status() int {
+	switch e.Code {
+	case ErrorAccessDenied, ErrorAccountNotLinked:
+		return http.StatusForbidden
+	case ErrorInvalidState:
+		return http.StatusBadRequest
+	case ErrorUnknownProvider:
+		return http.StatusNotFound
+	case ErrorAccountInUse, ErrorProviderLinked:
+		return http.StatusConflict
+	case ErrorProvider, ErrorExchangeFailed, ErrorInvalidProfile:
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// asSignInError classifies err, treating unclassified errors as internal.
+func asSignInError(err error) *SignInError {
+	var signInErr *SignInError
+	if errors.As(err, &signInErr) {
+		return signInErr
+	}
+
+	switch {
+	case errors.Is(err, ErrAccountNotLinked):
+		return NewSignInError(ErrorAccountNotLinked, err)
+	case errors.Is(err, ErrAccountInUse):
+		return NewSignInError(ErrorAccountInUse, err)
+	case errors.Is(err, ErrProviderLinked):
+		return NewSignInError(ErrorProviderLinked, err)
+	default:
+		return NewSignInError(ErrorInternal, err)
+	}
+}
+
+var errorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in failed</title></head>
+<body>
+<h1>Sign in failed</h1>
+<p>{{.Description}}</p>
+<p><a href="{{.SignIn}}">Try again</a></p>
+</body>
+</html>
+`))
+
+// Error renders the error page for the code in the error query parameter,
+// as HTML for browsers and as JSON otherwise.
+func (s *Service) Error(c echo.Context) error {
+	code := ErrorCode(c.QueryParam("error"))
+	if _, ok := errorDescriptions[code]; !ok {
+		code = ErrorInternal
+	}
+	return s.renderError(c, NewSignInError(code, nil))
+}
+
+func (s *Service) renderError(c echo.Context, err *SignInError) error {
+	description := errorDescriptions[err.Code]
+	if description == "" {
+		description = errorDescriptions[ErrorInternal]
+	}
+
+	if !acceptsHTML(c.Request()) {
+		return c.JSON(err.Status(), map[string]string{
+			"error":   string(err.Code),
+			"message": description,
+		})
+	}
+
+	signIn := s.pages.SignIn
+	if signIn == "" {
+		signIn = "/"
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	c.Response().WriteHeader(err.Status())
+	return errorPage.Execute(c.Response(), map[string]string{
+		"Description": description,
+		"SignIn":      signIn,
+	})
+}
+
+// signInFailed sends the browser to the error page, or renders the error
+// when no error page is configured.
+func (s *Service) signInFailed(c echo.Context, err error) error {
+	signInErr := asSignInError(err)
+	if signInErr.Status() >= http.StatusInternalServerError {
+		log.Printf("auth: sign in with %s failed: %v", c.Param("provider"), err)
+	}
+
+	if s.pages.Error != "" {
+		query := url.Values{}
+		query.Set("error", string(signInErr.Code))
+		return c.Redirect(http.StatusSeeOther, s.pages.Error+"?"+query.Encode())
+	}
+
+	return s.renderError(c, signInErr)
+}
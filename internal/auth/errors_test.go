@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestSignInErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		code ErrorCode
+		want int
+	}{
+		{NewSignInError(ErrorAccessDenied, nil), ErrorAccessDenied, http.StatusForbidden},
+		{NewSignInError(ErrorInvalidState, nil), ErrorInvalidState, http.StatusBadRequest},
+		{NewSignInError(ErrorExchangeFailed, nil), ErrorExchangeFailed, http.StatusBadGateway},
+		{ErrAccountInUse, ErrorAccountInUse, http.StatusConflict},
+		{ErrAccountNotLinked, ErrorAccountNotLinked, http.StatusForbidden},
+		{errors.New("database is locked"), ErrorInternal, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		signInErr := asSignInError(tt.err)
+		if signInErr.Code != tt.code || signInErr.Status() != tt.want {
+			t.Errorf("%v: expected %s (%d), got %s (%d)", tt.err, tt.code, tt.want, signInErr.Code, signInErr.Status())
+		}
+	}
+}
+
+func TestErrorPage(t *testing.T) {
+	e := echo.New()
+	s := Service{}
+
+	request := func(query, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/auth/error?"+query, nil)
+		req.Header.Set(echo.HeaderAccept, accept)
+		rec := httptest.NewRecorder()
+		if err := s.Error(e.NewContext(req, rec)); err != nil {
+			t.Fatalf("Error() error = %v", err)
+		}
+		return rec
+	}
+
+	rec := request("error=AccessDenied", echo.MIMEApplicationJSON)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), `"error":"AccessDenied"`) {
+		t.Errorf("expected an AccessDenied JSON response, got %d %s", rec.Code, rec.Body)
+	}
+
+	rec = request("error=InvalidState", "text/html,application/xhtml+xml")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), errorDescriptions[ErrorInvalidState]) {
+		t.Errorf("expected the InvalidState page, got %d %s", rec.Code, rec.Body)
+	}
+
+	rec = request("error=<script>", echo.MIMEApplicationJSON)
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "script") {
+		t.Errorf("expected unknown codes to render as internal errors, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestSignInFailedRedirectsToErrorPage(t *testing.T) {
+	s := Service{pages: Pages{Error: "/signin/error"}}
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/auth/callback/test", nil), rec)
+
+	if err := s.signInFailed(c, ErrAccountNotLinked); err != nil {
+		t.Fatalf("signInFailed() error = %v", err)
+	}
+	if location := rec.Header().Get(echo.HeaderLocation); location != "/signin/error?error=AccountNotLinked" {
+		t.Errorf("expected a redirect with the error code, got %s", location)
+	}
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type EventType string
+
+const (
+	// EventSignUp is published when a user signs in for the first time.
+	EventSignUp EventType = "signUp"
+	// EventSignIn is published on every successful sign in.
+	EventSignIn EventType = "signIn"
+	// EventSignOut is published when a session is ended by the user.
+	EventSignOut EventType = "signOut"
+	// EventLinkAccount is published when a provider account is attached to an existing user.
+	EventLinkAccount EventType = "linkAccount"
+	// EventCreateSession is published when a new session is started.
+	EventCreateSession EventType = "createSession"
+)
+
+type Event struct {
+	Type      EventType `json:"type"`
+	User      User      `json:"user"`
+	Account   *Account  `json:"account,omitempty"`
+	Provider  string    `json:"provider,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	Time      time.Time `json:"time"`
+}
+
+type Subscriber struct {
+	// Events lists the event types to receive. Empty means every event.
+	Events []EventType
+	// Handler receives the events.
+	Handler func(event Event)
+	// Async runs Handler in its own goroutine instead of blocking the request.
+	Async bool
+}
+
+type eventBus struct {
+	subscribers []Subscriber
+	wg          sync.WaitGroup
+}
+
+func newEventBus(subscribers []Subscriber) *eventBus {
+	return &eventBus{subscribers: subscribers}
+}
+
+func (b *eventBus) publish(event Event) {
+	for _, sub := range b.subscribers {
+		if len(sub.Events) > 0 && !slices.Contains(sub.Events, event.Type) {
+			continue
+		}
+
+		if !sub.Async {
+			deliver(sub.Handler, event)
+			continue
+		}
+
+		b.wg.Add(1)
+		go func(handler func(Event)) {
+			defer b.wg.Done()
+			deliver(handler, event)
+		}(sub.Handler)
+	}
+}
+
+// wait blocks until every asynchronous delivery has finished.
+func (b *eventBus) wait() {
+	b.wg.Wait()
+}
+
+// deliver shields the request from a panicking subscriber.
+func deliver(handler func(Event), event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("auth: %s subscriber panicked: %v", event.Type, r)
+		}
+	}()
+	handler(event)
+}
+
+// publish fills in the request details of event and hands it to the subscribers.
+func (s *Service) publish(c echo.Context, event Event) {
+	event.IP = s.clientIP(c)
+	event.UserAgent = c.Request().UserAgent()
+	event.Time = time.Now()
+	s.events.publish(event)
+}
@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestEventBus(t *testing.T) {
+	var signIns, all, async atomic.Int32
+
+	bus := newEventBus([]Subscriber{
+		{Events: []EventType{EventSignIn}, Handler: func(Event) { signIns.Add(1) }},
+		{Handler: func(Event) { all.Add(1) }},
+		{Handler: func(Event) { async.Add(1) }, Async: true},
+		{Handler: func(Event) { panic("boom") }},
+	})
+
+	bus.publish(Event{Type: EventSignIn})
+	bus.publish(Event{Type: EventSignOut})
+	bus.wait()
+
+	if signIns.Load() != 1 {
+		t.Errorf("Expected filtered subscriber to receive 1 event, got %d", signIns.Load())
+	}
+	if all.Load() != 2 {
+		t.Errorf("Expected subscriber to receive 2 events, got %d", all.Load())
+	}
+	if async.Load() != 2 {
+		t.Errorf("Expected async subscriber to receive 2 events, got %d", async.Load())
+	}
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+type JWTOptions struct {
+	// Secret keys the session tokens. Defaults to AUTH_SECRET. The payload
+	// can be customized with Callbacks.JWT.
+	Secret string
+}
+
+// encodeJWT signs claims with HS256 and encrypts the result with AES-256-GCM,
+// so the session cookie is both tamper-proof and opaque to the client.
+func (o JWTOptions) encodeJWT(claims jwt.MapClaims) (string, error) {
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(deriveKey(o.Secret, "session signing key"))
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := newGCM(o.Secret)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(signed), nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// decodeJWT reverses encodeJWT and validates the standard time claims.
+func (o JWTOptions) decodeJWT(token string) (jwt.MapClaims, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	gcm, err := newGCM(o.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	signed, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(string(signed), claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return deriveKey(o.Secret, "session signing key"), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// sessionClaims returns the default payload of a jwt session.
+func sessionClaims(user User, createdAt, expires time.Time) jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":       user.Id,
+		"name":      user.Name,
+		"email":     user.Email,
+		"picture":   user.Image,
+		"jti":       uuid.New().String(),
+		"iat":       time.Now().Unix(),
+		"exp":       expires.Unix(),
+		"auth_time": createdAt.Unix(),
+	}
+}
+
+// sessionFromClaims rebuilds the session and user described by a decoded token.
+func sessionFromClaims(token string, claims jwt.MapClaims) (Session, User) {
+	str := func(key string) string {
+		val, _ := claims[key].(string)
+		return val
+	}
+	unix := func(key string) time.Time {
+		val, _ := claims[key].(float64)
+		return time.Unix(int64(val), 0)
+	}
+
+	user := User{
+		Id:    str("sub"),
+		Name:  str("name"),
+		Email: str("email"),
+		Image: str("picture"),
+	}
+
+	session := Session{
+		SessionToken: token,
+		UserId:       user.Id,
+		Expires:      unix("exp"),
+		CreatedAt:    unix("auth_time"),
+		claims:       claims,
+	}
+
+	return session, user
+}
+
+func deriveKey(secret, label string) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(label))
+	return h.Sum(nil)
+}
+
+func newGCM(secret string) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveKey(secret, "session encryption key"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJWTSessionRoundTrip(t *testing.T) {
+	opts := JWTOptions{Secret: "test-secret"}
+	now := time.Now()
+	user := User{Id: "user-1", Name: "Test", Email: "test@example.com"}
+
+	token, err := opts.encodeJWT(sessionClaims(user, now, now.Add(time.Hour)))
+	if err != nil {
+		t.Fatalf("encodeJWT() error = %v", err)
+	}
+
+	claims, err := opts.decodeJWT(token)
+	if err != nil {
+		t.Fatalf("decodeJWT() error = %v", err)
+	}
+
+	session, decoded := sessionFromClaims(token, claims)
+	if !reflect.DeepEqual(decoded, user) {
+		t.Errorf("Expected user %v, got %v", user, decoded)
+	}
+	if session.Expires.Unix() != now.Add(time.Hour).Unix() {
+		t.Errorf("Expected expires %v, got %v", now.Add(time.Hour), session.Expires)
+	}
+}
+
+func TestJWTSessionRejectsInvalidTokens(t *testing.T) {
+	opts := JWTOptions{Secret: "test-secret"}
+	now := time.Now()
+	user := User{Id: "user-1"}
+
+	token, _ := opts.encodeJWT(sessionClaims(user, now, now.Add(time.Hour)))
+	if _, err := (JWTOptions{Secret: "other-secret"}).decodeJWT(token); err == nil {
+		t.Errorf("Expected token encrypted with another secret to fail")
+	}
+
+	tampered := []byte(token)
+	tampered[len(tampered)/2] ^= 1
+	if _, err := opts.decodeJWT(string(tampered)); err == nil {
+		t.Errorf("Expected tampered token to fail")
+	}
+
+	expired, _ := opts.encodeJWT(sessionClaims(user, now, now.Add(-time.Minute)))
+	if _, err := opts.decodeJWT(expired); err == nil {
+		t.Errorf("Expected expired token to fail")
+	}
+}
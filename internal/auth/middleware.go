@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const sessionContextKey = "auth.session"
+
+type resolvedSession struct {
+	session Session
+	user    User
+	err     error
+	// authorized is set once the roles and permissions of user are loaded.
+	authorized bool
+}
+
+// RequireAuth rejects requests without a valid session. API clients get a
+// 401 JSON response, browsers are redirected to Pages.SignIn when it is set.
+func (s *Service) RequireAuth() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if _, _, err := s.resolveSession(c); err != nil {
+				return s.unauthorized(c)
+			}
+			return next(c)
+		}
+	}
+}
+
+// OptionalAuth resolves the session when there is one and lets every request through.
+func (s *Service) OptionalAuth() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			s.resolveSession(c)
+			return next(c)
+		}
+	}
+}
+
+// GetUser returns the user resolved by RequireAuth or OptionalAuth.
+func GetUser(c echo.Context) (User, bool) {
+	r, ok := c.Get(sessionContextKey).(*resolvedSession)
+	if !ok || r.err != nil {
+		return User{}, false
+	}
+	return r.user, true
+}
+
+// GetSession returns the session resolved by RequireAuth or OptionalAuth.
+func GetSession(c echo.Context) (Session, bool) {
+	r, ok := c.Get(sessionContextKey).(*resolvedSession)
+	if !ok || r.err != nil {
+		return Session{}, false
+	}
+	return r.session, true
+}
+
+// resolveSession looks up the session of the request at most once and
+// caches the result, including failures, in the context.
+func (s *Service) resolveSession(c echo.Context) (Session, User, error) {
+	if r, ok := c.Get(sessionContextKey).(*resolvedSession); ok {
+		return r.session, r.user, r.err
+	}
+
+	session, user, err := s.getSession(c)
+	c.Set(sessionContextKey, &resolvedSession{
+		session: session,
+		user:    user,
+		err:     err,
+	})
+
+	return session, user, err
+}
+
+func (s *Service) unauthorized(c echo.Context) error {
+	if s.pages.SignIn != "" && acceptsHTML(c.Request()) {
+		query := url.Values{}
+		query.Set("callbackUrl", c.Request().URL.RequestURI())
+		return c.Redirect(http.StatusSeeOther, s.pages.SignIn+"?"+query.Encode())
+	}
+
+	return c.JSON(http.StatusUnauthorized, map[string]string{
+		"error": "invalid session",
+	})
+}
+
+// acceptsHTML reports whether the request comes from a browser navigation
+// rather than an API client.
+func acceptsHTML(req *http.Request) bool {
+	return strings.Contains(req.Header.Get(echo.HeaderAccept), echo.MIMETextHTML)
+}
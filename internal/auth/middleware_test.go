@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func newJWTTestService(t *testing.T) Service {
+	return New(AuthServiceOptions{
+		Session: SessionOptions{
+			Strategy: SessionStrategyJWT,
+			JWT:      JWTOptions{Secret: "test-secret"},
+		},
+		Pages: Pages{SignIn: "/signin"},
+	})
+}
+
+func sessionCookie(t *testing.T, s Service, user User) *http.Cookie {
+	now := time.Now()
+	token, err := s.session.JWT.encodeJWT(sessionClaims(user, now, now.Add(time.Hour)))
+	if err != nil {
+		t.Fatalf("encodeJWT() error = %v", err)
+	}
+	return &http.Cookie{Name: sessionCookieName, Value: token}
+}
+
+func TestRequireAuth(t *testing.T) {
+	s := newJWTTestService(t)
+	e := echo.New()
+	handler := s.RequireAuth()(func(c echo.Context) error {
+		user, ok := GetUser(c)
+		if !ok {
+			t.Errorf("Expected user in context")
+		}
+		return c.String(http.StatusOK, user.Id)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/private", nil)
+	req.AddCookie(sessionCookie(t, s, User{Id: "user-1"}))
+	rec := httptest.NewRecorder()
+	if err := handler(e.NewContext(req, rec)); err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if rec.Code != http.StatusOK || rec.Body.String() != "user-1" {
+		t.Errorf("Expected 200 user-1, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/private", nil)
+	rec = httptest.NewRecorder()
+	if err := handler(e.NewContext(req, rec)); err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected API request to get 401, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/private?tab=1", nil)
+	req.Header.Set(echo.HeaderAccept, "text/html,application/xhtml+xml")
+	rec = httptest.NewRecorder()
+	if err := handler(e.NewContext(req, rec)); err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if rec.Code != http.StatusSeeOther || rec.Header().Get(echo.HeaderLocation) != "/signin?callbackUrl=%2Fprivate%3Ftab%3D1" {
+		t.Errorf("Expected browser request to be redirected, got %d %s", rec.Code, rec.Header().Get(echo.HeaderLocation))
+	}
+}
+
+func TestOptionalAuth(t *testing.T) {
+	s := newJWTTestService(t)
+	e := echo.New()
+	handler := s.OptionalAuth()(func(c echo.Context) error {
+		if _, ok := GetUser(c); ok {
+			t.Errorf("Expected no user in context")
+		}
+		return c.NoContent(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	if err := handler(e.NewContext(req, rec)); err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", rec.Code)
+	}
+}
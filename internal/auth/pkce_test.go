@@ -0,0 +1,17 @@
+package auth
+
+import "testing"
+
+func TestCodeChallengeS256(t *testing.T) {
+	// Example from RFC 7636, Appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	expected := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if challenge := CodeChallengeS256(verifier); challenge != expected {
+		t.Errorf("Expected challenge %s, got %s", expected, challenge)
+	}
+
+	if len(GenerateCodeVerifier()) < 43 {
+		t.Errorf("Expected verifier of at least 43 characters")
+	}
+}
@@ -1,6 +1,9 @@
 package auth
 
-import "net/http"
+import (
+	"context"
+	"net/http"
+)
 
 type ProviderData struct {
 	Id     string `json:"id"`
@@ -10,12 +13,50 @@ type ProviderData struct {
 	Image  string `json:"image"`
 }
 
+// Checks lists the optional protections a provider performs during sign in.
+type Checks struct {
+	// PKCE sends an S256 code challenge with the authorization request.
+	PKCE bool
+	// Nonce binds the id_token to the sign in through the nonce claim.
+	Nonce bool
+	// FormPost means the provider returns with a cross-site form post
+	// (response_mode=form_post), so the sign in cookies must allow it.
+	FormPost bool
+}
+
+// AuthorizationParams carries the per sign in values minted by the Service
+// to the provider, both when redirecting to it and when handling its callback.
+type AuthorizationParams struct {
+	RedirectURI  string
+	State        string
+	CodeVerifier string
+	Nonce        string
+}
+
 type Provider interface {
 	GetId() string
 	GetType() string
 	GetPublicData() ProviderData
-	GetRedirectURL(base string) string
-	HandleCallback(request *http.Request) (Profile, TokenSet, error)
+	// AllowsEmailLinking reports whether accounts of this provider may be
+	// linked to an existing user with the same verified email.
+	AllowsEmailLinking() bool
+	GetChecks() Checks
+	GetRedirectURL(params AuthorizationParams) string
+	// HandleCallback completes the sign in. ctx bounds the calls made to
+	// the provider and is cancelled when the browser goes away.
+	HandleCallback(ctx context.Context, request *http.Request, params AuthorizationParams) (Profile, TokenSet, error)
+}
+
+// TokenRefresher is implemented by providers that can renew an access token
+// with the refresh_token grant.
+type TokenRefresher interface {
+	Refresh(ctx context.Context, refreshToken string) (TokenSet, error)
+}
+
+// TokenRevoker is implemented by providers that can invalidate issued
+// tokens, as described by RFC 7009.
+type TokenRevoker interface {
+	Revoke(ctx context.Context, token, tokenTypeHint string) error
 }
 
 type Providers map[string]Provider
@@ -0,0 +1,126 @@
+package providers
+
+import (
+	"context"
+	"echo-server/internal/auth"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// AppleProvider implements Sign in with Apple. Apple posts the callback as a
+// form and sends the user's name only with the first sign in, so the
+// callback is handled on top of OAuthProvider.
+type AppleProvider struct {
+	OAuthProvider
+}
+
+type AppleOptions struct {
+	// ClientId is the Services ID registered for the web sign in.
+	ClientId string
+	TeamId   string
+	KeyId    string
+	// PrivateKey is the contents of the .p8 key downloaded from Apple.
+	PrivateKey string
+}
+
+// Apple returns a Sign in with Apple provider configured from the
+// APPLE_CLIENT_ID, APPLE_TEAM_ID, APPLE_KEY_ID and APPLE_PRIVATE_KEY (or
+// APPLE_PRIVATE_KEY_PATH) environment variables.
+func Apple() AppleProvider {
+	privateKey := os.Getenv("APPLE_PRIVATE_KEY")
+	if path := os.Getenv("APPLE_PRIVATE_KEY_PATH"); privateKey == "" && path != "" {
+		if key, err := os.ReadFile(path); err == nil {
+			privateKey = string(key)
+		}
+	}
+
+	return NewApple(AppleOptions{
+		ClientId:   os.Getenv("APPLE_CLIENT_ID"),
+		TeamId:     os.Getenv("APPLE_TEAM_ID"),
+		KeyId:      os.Getenv("APPLE_KEY_ID"),
+		PrivateKey: privateKey,
+	})
+}
+
+func NewApple(opts AppleOptions) AppleProvider {
+	return AppleProvider{OAuthProvider{
+		Id:                   "apple",
+		Name:                 "Apple",
+		Issuer:               "https://appleid.apple.com",
+		Type:                 "oidc",
+		Image:                "https://authjs.dev/img/providers/apple.svg",
+		Scopes:               []string{"name", "email"},
+		Authorization:        "https://appleid.apple.com/auth/authorize",
+		Token:                "https://appleid.apple.com/auth/token",
+		JWKS:                 "https://appleid.apple.com/auth/keys",
+		ResponseMode:         "form_post",
+		AllowEmailLinking:    true,
+		ClientId:             opts.ClientId,
+		GenerateClientSecret: appleClientSecret(opts),
+		Checks:               auth.Checks{Nonce: true, FormPost: true},
+	}}
+}
+
+// appleClientSecret returns a generator of the ES256 signed JWT Apple
+// expects as client secret. The key is parsed when the secret is first
+// needed, so a missing key only fails sign ins with Apple.
+func appleClientSecret(opts AppleOptions) func() (string, error) {
+	return func() (string, error) {
+		key, err := jwt.ParseECPrivateKeyFromPEM([]byte(opts.PrivateKey))
+		if err != nil {
+			return "", fmt.Errorf("invalid apple private key: %w", err)
+		}
+
+		now := time.Now()
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.StandardClaims{
+			Issuer:    opts.TeamId,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(5 * time.Minute).Unix(),
+			Audience:  "https://appleid.apple.com",
+			Subject:   opts.ClientId,
+		})
+		token.Header["kid"] = opts.KeyId
+
+		return token.SignedString(key)
+	}
+}
+
+type appleUser struct {
+	Name struct {
+		FirstName string `json:"firstName"`
+		LastName  string `json:"lastName"`
+	} `json:"name"`
+	Email string `json:"email"`
+}
+
+// HandleCallback implements auth.Provider.
+func (p AppleProvider) HandleCallback(ctx context.Context, req *http.Request, params auth.AuthorizationParams) (auth.Profile, auth.TokenSet, error) {
+	// Apple reports that the user declined with its own error code.
+	if req.FormValue("error") == "user_cancelled_authorize" {
+		err := fmt.Errorf("user_cancelled_authorize")
+		return auth.Profile{}, auth.TokenSet{}, auth.NewSignInError(auth.ErrorAccessDenied, err)
+	}
+
+	profile, tokenSet, err := p.OAuthProvider.HandleCallback(ctx, req, params)
+	if err != nil {
+		return profile, tokenSet, err
+	}
+
+	// Apple only includes the user's name in the form of the first sign in.
+	var user appleUser
+	if raw := req.FormValue("user"); raw != "" && json.Unmarshal([]byte(raw), &user) == nil {
+		profile.GivenName = user.Name.FirstName
+		profile.FamilyName = user.Name.LastName
+		if profile.Name == "" {
+			profile.Name = strings.TrimSpace(user.Name.FirstName + " " + user.Name.LastName)
+		}
+	}
+
+	return profile, tokenSet, nil
+}
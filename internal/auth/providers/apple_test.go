@@ -0,0 +1,224 @@
+package providers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"echo-server/internal/auth"
+	"echo-server/internal/auth/adapters"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+)
+
+// applePrivateKey returns a key and its .p8 encoding, as downloaded from Apple.
+func applePrivateKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+// newTestApple returns an Apple provider whose token endpoint issues an
+// id_token for apple-user with the nonce returned by nonce.
+func newTestApple(t *testing.T, nonce func() string) AppleProvider {
+	ks := newTestKeyServer(t)
+	ks.addKey(t, "apple-key")
+
+	token := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "apple-code" || r.FormValue("client_secret") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "invalid_grant"}`))
+			return
+		}
+		now := time.Now()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "apple-access",
+			"token_type":   "Bearer",
+			"id_token": ks.sign(t, "apple-key", jwt.MapClaims{
+				"iss":            "https://appleid.apple.com",
+				"aud":            "com.example.web",
+				"sub":            "apple-user",
+				"email":          "apple-user@privaterelay.appleid.com",
+				"email_verified": "true",
+				"nonce":          nonce(),
+				"iat":            now.Unix(),
+				"exp":            now.Add(time.Hour).Unix(),
+			}),
+		})
+	}))
+	t.Cleanup(token.Close)
+
+	_, p8 := applePrivateKey(t)
+	p := NewApple(AppleOptions{ClientId: "com.example.web", TeamId: "TEAM123456", KeyId: "KEY1234567", PrivateKey: p8})
+	p.Token = token.URL
+	p.JWKS = ks.URL
+	return p
+}
+
+// applePost returns the form Apple posts to the callback.
+func applePost(form url.Values) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/auth/callback/apple", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestAppleClientSecret(t *testing.T) {
+	key, p8 := applePrivateKey(t)
+
+	p := NewApple(AppleOptions{ClientId: "com.example.web", TeamId: "TEAM123456", KeyId: "KEY1234567", PrivateKey: p8})
+
+	secret, err := p.GenerateClientSecret()
+	if err != nil {
+		t.Fatalf("GenerateClientSecret() error = %v", err)
+	}
+
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(secret, claims, func(t *jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	})
+	if err != nil {
+		t.Fatalf("client secret does not verify: %v", err)
+	}
+
+	if token.Method != jwt.SigningMethodES256 || token.Header["kid"] != "KEY1234567" {
+		t.Errorf("Expected ES256 with kid KEY1234567, got %v %v", token.Header["alg"], token.Header["kid"])
+	}
+	if claims["iss"] != "TEAM123456" || claims["sub"] != "com.example.web" || claims["aud"] != "https://appleid.apple.com" {
+		t.Errorf("Unexpected client secret claims %v", claims)
+	}
+
+	if _, err := NewApple(AppleOptions{}).GenerateClientSecret(); err == nil {
+		t.Errorf("Expected missing key to fail")
+	}
+}
+
+func TestAppleRedirectURLUsesFormPost(t *testing.T) {
+	redirect, _ := url.Parse(NewApple(AppleOptions{}).GetRedirectURL(auth.AuthorizationParams{}))
+	if redirect.Query().Get("response_mode") != "form_post" {
+		t.Errorf("Expected response_mode=form_post, got %s", redirect.Query().Get("response_mode"))
+	}
+}
+
+func TestAppleCallbackReadsUserOnFirstSignIn(t *testing.T) {
+	p := newTestApple(t, func() string { return "nonce" })
+	params := auth.AuthorizationParams{Nonce: "nonce"}
+
+	profile, tokenSet, err := p.HandleCallback(context.Background(), applePost(url.Values{
+		"code": {"apple-code"},
+		"user": {`{"name":{"firstName":"Jane","lastName":"Appleseed"},"email":"apple-user@privaterelay.appleid.com"}`},
+	}), params)
+	if err != nil {
+		t.Fatalf("HandleCallback() error = %v", err)
+	}
+	if profile.Id != "apple-user" || tokenSet.AccessToken != "apple-access" {
+		t.Errorf("Expected apple-user signed in, got %+v %+v", profile, tokenSet)
+	}
+	if profile.Name != "Jane Appleseed" || profile.GivenName != "Jane" || profile.FamilyName != "Appleseed" {
+		t.Errorf("Expected the name of the first sign in, got %+v", profile)
+	}
+
+	// Later sign ins only carry the code.
+	profile, _, err = p.HandleCallback(context.Background(), applePost(url.Values{"code": {"apple-code"}}), params)
+	if err != nil {
+		t.Fatalf("HandleCallback() error = %v", err)
+	}
+	if profile.Id != "apple-user" || profile.Name != "" || profile.GivenName != "" {
+		t.Errorf("Expected a profile without a name, got %+v", profile)
+	}
+}
+
+func TestAppleCallbackCancelled(t *testing.T) {
+	p := newTestApple(t, func() string { return "nonce" })
+
+	_, _, err := p.HandleCallback(context.Background(), applePost(url.Values{
+		"error": {"user_cancelled_authorize"},
+		"state": {"state"},
+	}), auth.AuthorizationParams{Nonce: "nonce"})
+
+	var signInErr *auth.SignInError
+	if !errors.As(err, &signInErr) || signInErr.Code != auth.ErrorAccessDenied {
+		t.Errorf("Expected %s, got %v", auth.ErrorAccessDenied, err)
+	}
+}
+
+// Apple posts the callback from its own site, so the browser only sends the
+// sign in cookies when they are SameSite=None.
+func TestAppleFormPostCallback(t *testing.T) {
+	var nonce atomic.Value
+	nonce.Store("")
+	p := newTestApple(t, func() string { return nonce.Load().(string) })
+
+	service := auth.New(auth.AuthServiceOptions{
+		Providers: []auth.Provider{p},
+		Adapter:   adapters.Memory(),
+		Session:   auth.SessionOptions{PurgeInterval: -1},
+	})
+	t.Cleanup(service.Close)
+
+	e := echo.New()
+	e.GET("/auth/login/:provider", service.Login)
+	e.POST("/auth/callback/:provider", service.Callback)
+	app := httptest.NewTLSServer(e)
+	t.Cleanup(app.Close)
+
+	browser := *app.Client()
+	browser.Jar, _ = cookiejar.New(nil)
+	browser.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	res, err := browser.Get(app.URL + "/auth/login/apple")
+	if err != nil {
+		t.Fatalf("GET /auth/login/apple: %v", err)
+	}
+	res.Body.Close()
+	var state *http.Cookie
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == "auth.state" {
+			state = cookie
+		}
+	}
+	if state == nil || state.SameSite != http.SameSiteNoneMode {
+		t.Errorf("Expected a SameSite=None state cookie, got %v", state)
+	}
+	redirect, err := url.Parse(res.Header.Get(echo.HeaderLocation))
+	if err != nil || redirect.Query().Get("response_mode") != "form_post" {
+		t.Fatalf("Expected a form_post redirect to Apple, got %s", res.Header.Get(echo.HeaderLocation))
+	}
+	nonce.Store(redirect.Query().Get("nonce"))
+
+	req, _ := http.NewRequest(http.MethodPost, app.URL+"/auth/callback/apple", strings.NewReader(url.Values{
+		"code":  {"apple-code"},
+		"state": {redirect.Query().Get("state")},
+		"user":  {`{"name":{"firstName":"Jane","lastName":"Appleseed"}}`},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+	res, err = browser.Do(req)
+	if err != nil {
+		t.Fatalf("POST /auth/callback/apple: %v", err)
+	}
+	defer res.Body.Close()
+
+	var user auth.User
+	json.NewDecoder(res.Body).Decode(&user)
+	if res.StatusCode != http.StatusOK || user.Name != "Jane Appleseed" {
+		t.Errorf("Expected Jane Appleseed to sign in, got %d %+v", res.StatusCode, user)
+	}
+}
@@ -0,0 +1,70 @@
+package providers
+
+import (
+	"context"
+	"echo-server/internal/auth"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func Discord() OAuthProvider {
+	return OAuthProvider{
+		Id:                "discord",
+		Name:              "Discord",
+		Type:              "oauth",
+		Image:             "https://authjs.dev/img/providers/discord.svg",
+		Scopes:            []string{"identify", "email"},
+		Authorization:     "https://discord.com/oauth2/authorize",
+		Token:             "https://discord.com/api/oauth2/token",
+		UserInfo:          "https://discord.com/api/users/@me",
+		AllowEmailLinking: true,
+		ClientId:          os.Getenv("DISCORD_CLIENT_ID"),
+		ClientSecret:      os.Getenv("DISCORD_CLIENT_SECRET"),
+		Profile:           discordProfile,
+	}
+}
+
+// discordProfile maps the /users/@me response.
+func discordProfile(ctx context.Context, p OAuthProvider, raw map[string]interface{}, token auth.TokenSet) (auth.Profile, error) {
+	profile := auth.Profile{
+		Id:       claimString(raw, "id"),
+		Name:     claimString(raw, "global_name"),
+		Nickname: claimString(raw, "username"),
+		Email:    claimString(raw, "email"),
+		Locale:   claimString(raw, "locale"),
+	}
+	if profile.Name == "" {
+		profile.Name = profile.Nickname
+	}
+	if verified, ok := raw["verified"].(bool); ok {
+		profile.EmailVerified = verified && profile.Email != ""
+	}
+
+	profile.Picture = discordAvatar(profile.Id, claimString(raw, "avatar"), claimString(raw, "discriminator"))
+
+	return profile, nil
+}
+
+// discordAvatar builds the CDN URL of a user's avatar, falling back to the
+// default avatar Discord assigns when none is uploaded.
+func discordAvatar(id, avatar, discriminator string) string {
+	if avatar != "" {
+		format := "png"
+		if strings.HasPrefix(avatar, "a_") {
+			format = "gif"
+		}
+		return fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.%s", id, avatar, format)
+	}
+
+	var index uint64
+	if discriminator == "" || discriminator == "0" {
+		snowflake, _ := strconv.ParseUint(id, 10, 64)
+		index = (snowflake >> 22) % 6
+	} else {
+		number, _ := strconv.ParseUint(discriminator, 10, 64)
+		index = number % 5
+	}
+	return fmt.Sprintf("https://cdn.discordapp.com/embed/avatars/%d.png", index)
+}
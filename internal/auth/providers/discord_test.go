@@ -0,0 +1,50 @@
+package providers
+
+import (
+	"context"
+	"echo-server/internal/auth"
+	"testing"
+)
+
+// Recorded from discord.com/api/users/@me, trimmed to the fields we read.
+const discordUserResponse = `{
+	"id": "80351110224678912",
+	"username": "nelly",
+	"global_name": "Nelly",
+	"discriminator": "0",
+	"avatar": "8342729096ea3675442027381ff50dfe",
+	"verified": true,
+	"email": "nelly@discord.com",
+	"locale": "en-US"
+}`
+
+func TestDiscordProfile(t *testing.T) {
+	p := Discord()
+
+	profile, err := p.Profile(context.Background(), p, decodeRecorded(t, discordUserResponse), auth.TokenSet{})
+	if err != nil {
+		t.Fatalf("Profile() error = %v", err)
+	}
+
+	expected := auth.Profile{
+		Id:            "80351110224678912",
+		Name:          "Nelly",
+		Nickname:      "nelly",
+		Email:         "nelly@discord.com",
+		EmailVerified: true,
+		Locale:        "en-US",
+		Picture:       "https://cdn.discordapp.com/avatars/80351110224678912/8342729096ea3675442027381ff50dfe.png",
+	}
+	if profile != expected {
+		t.Errorf("Expected %+v, got %+v", expected, profile)
+	}
+}
+
+func TestDiscordDefaultAvatar(t *testing.T) {
+	if avatar := discordAvatar("80351110224678912", "", "0"); avatar != "https://cdn.discordapp.com/embed/avatars/5.png" {
+		t.Errorf("Expected default avatar 5, got %s", avatar)
+	}
+	if avatar := discordAvatar("80351110224678912", "", "1337"); avatar != "https://cdn.discordapp.com/embed/avatars/2.png" {
+		t.Errorf("Expected legacy default avatar 2, got %s", avatar)
+	}
+}
@@ -0,0 +1,63 @@
+package providers
+
+import (
+	"context"
+	"echo-server/internal/auth"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func GitHub() OAuthProvider {
+	return OAuthProvider{
+		Id:                "github",
+		Name:              "GitHub",
+		Type:              "oauth",
+		Image:             "https://authjs.dev/img/providers/github.svg",
+		Scopes:            []string{"read:user", "user:email"},
+		Authorization:     "https://github.com/login/oauth/authorize",
+		Token:             "https://github.com/login/oauth/access_token",
+		UserInfo:          "https://api.github.com/user",
+		AllowEmailLinking: true,
+		ClientId:          os.Getenv("GITHUB_CLIENT_ID"),
+		ClientSecret:      os.Getenv("GITHUB_CLIENT_SECRET"),
+		Profile:           githubProfile,
+	}
+}
+
+type githubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+// githubProfile maps the /user response. The email on /user is the public
+// one, which may be empty or unverified, so the primary verified address
+// is read from /user/emails instead.
+func githubProfile(ctx context.Context, p OAuthProvider, raw map[string]interface{}, token auth.TokenSet) (auth.Profile, error) {
+	profile := auth.Profile{
+		Id:       claimString(raw, "id"),
+		Name:     claimString(raw, "name"),
+		Nickname: claimString(raw, "login"),
+		Picture:  claimString(raw, "avatar_url"),
+		Profile:  claimString(raw, "html_url"),
+		Website:  claimString(raw, "blog"),
+	}
+	if profile.Name == "" {
+		profile.Name = profile.Nickname
+	}
+
+	var emails []githubEmail
+	if err := p.GetJSON(ctx, strings.TrimSuffix(p.UserInfo, "/user")+"/user/emails", token.AccessToken, &emails); err != nil {
+		return auth.Profile{}, fmt.Errorf("fetching github emails: %w", err)
+	}
+
+	for _, email := range emails {
+		if email.Primary && email.Verified {
+			profile.Email = email.Email
+			profile.EmailVerified = true
+		}
+	}
+
+	return profile, nil
+}
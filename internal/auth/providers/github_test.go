@@ -0,0 +1,63 @@
+package providers
+
+import (
+	"context"
+	"echo-server/internal/auth"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Recorded from api.github.com, trimmed to the fields we read.
+const (
+	githubUserResponse = `{
+		"login": "octocat",
+		"id": 583231,
+		"avatar_url": "https://avatars.githubusercontent.com/u/583231?v=4",
+		"html_url": "https://github.com/octocat",
+		"name": "The Octocat",
+		"blog": "https://github.blog",
+		"email": null
+	}`
+	githubEmailsResponse = `[
+		{"email": "octocat@users.noreply.github.com", "primary": false, "verified": true, "visibility": null},
+		{"email": "octocat@github.com", "primary": true, "verified": true, "visibility": "public"},
+		{"email": "old@example.com", "primary": false, "verified": false, "visibility": null}
+	]`
+)
+
+func TestGitHubProfile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/user":
+			w.Write([]byte(githubUserResponse))
+		case "/user/emails":
+			w.Write([]byte(githubEmailsResponse))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	p := GitHub()
+	p.UserInfo = server.URL + "/user"
+
+	profile, err := p.GetProfile(context.Background(), &auth.TokenSet{AccessToken: "token"}, "")
+	if err != nil {
+		t.Fatalf("GetProfile() error = %v", err)
+	}
+
+	expected := auth.Profile{
+		Id:            "583231",
+		Name:          "The Octocat",
+		Nickname:      "octocat",
+		Picture:       "https://avatars.githubusercontent.com/u/583231?v=4",
+		Profile:       "https://github.com/octocat",
+		Website:       "https://github.blog",
+		Email:         "octocat@github.com",
+		EmailVerified: true,
+	}
+	if profile != expected {
+		t.Errorf("Expected %+v, got %+v", expected, profile)
+	}
+}
@@ -0,0 +1,51 @@
+package providers
+
+import (
+	"context"
+	"echo-server/internal/auth"
+	"os"
+	"strings"
+)
+
+// GitLab returns a provider for gitlab.com, or for a self-hosted instance
+// when baseURL is set.
+func GitLab(baseURL string) OAuthProvider {
+	if baseURL == "" {
+		baseURL = "https://gitlab.com"
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	return OAuthProvider{
+		Id:                "gitlab",
+		Name:              "GitLab",
+		Issuer:            baseURL,
+		Type:              "oauth",
+		Image:             "https://authjs.dev/img/providers/gitlab.svg",
+		Scopes:            []string{"read_user"},
+		Authorization:     baseURL + "/oauth/authorize",
+		Token:             baseURL + "/oauth/token",
+		UserInfo:          baseURL + "/api/v4/user",
+		AllowEmailLinking: true,
+		ClientId:          os.Getenv("GITLAB_CLIENT_ID"),
+		ClientSecret:      os.Getenv("GITLAB_CLIENT_SECRET"),
+		Checks:            auth.Checks{PKCE: true},
+		Profile:           gitlabProfile,
+	}
+}
+
+// gitlabProfile maps the /api/v4/user response. GitLab only exposes the
+// primary email, which is verified once confirmed_at is set.
+func gitlabProfile(ctx context.Context, p OAuthProvider, raw map[string]interface{}, token auth.TokenSet) (auth.Profile, error) {
+	profile := auth.Profile{
+		Id:       claimString(raw, "id"),
+		Name:     claimString(raw, "name"),
+		Nickname: claimString(raw, "username"),
+		Email:    claimString(raw, "email"),
+		Picture:  claimString(raw, "avatar_url"),
+		Profile:  claimString(raw, "web_url"),
+		Website:  claimString(raw, "website_url"),
+	}
+	profile.EmailVerified = profile.Email != "" && claimString(raw, "confirmed_at") != ""
+
+	return profile, nil
+}
@@ -0,0 +1,56 @@
+package providers
+
+import (
+	"context"
+	"echo-server/internal/auth"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// Recorded from gitlab.com/api/v4/user, trimmed to the fields we read.
+const gitlabUserResponse = `{
+	"id": 1,
+	"username": "john_smith",
+	"name": "John Smith",
+	"avatar_url": "https://gitlab.com/uploads/user/avatar/1/index.jpg",
+	"web_url": "https://gitlab.com/john_smith",
+	"website_url": "",
+	"email": "john@example.com",
+	"confirmed_at": "2012-05-23T09:05:22Z"
+}`
+
+func decodeRecorded(t *testing.T, body string) map[string]interface{} {
+	var raw map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		t.Fatalf("could not decode recorded response: %v", err)
+	}
+	return raw
+}
+
+func TestGitLabProfile(t *testing.T) {
+	p := GitLab("https://gitlab.example.com/")
+	if p.Authorization != "https://gitlab.example.com/oauth/authorize" || p.UserInfo != "https://gitlab.example.com/api/v4/user" {
+		t.Errorf("Expected endpoints of the self-hosted instance, got %s and %s", p.Authorization, p.UserInfo)
+	}
+
+	profile, err := p.Profile(context.Background(), p, decodeRecorded(t, gitlabUserResponse), auth.TokenSet{})
+	if err != nil {
+		t.Fatalf("Profile() error = %v", err)
+	}
+
+	expected := auth.Profile{
+		Id:            "1",
+		Name:          "John Smith",
+		Nickname:      "john_smith",
+		Email:         "john@example.com",
+		EmailVerified: true,
+		Picture:       "https://gitlab.com/uploads/user/avatar/1/index.jpg",
+		Profile:       "https://gitlab.com/john_smith",
+	}
+	if profile != expected {
+		t.Errorf("Expected %+v, got %+v", expected, profile)
+	}
+}
@@ -1,6 +1,11 @@
 package providers
 
-import "os"
+import (
+	"echo-server/internal/auth"
+	"os"
+
+	"github.com/golang-jwt/jwt"
+)
 
 func Google() OAuthProvider {
 	return OAuthProvider{
@@ -13,8 +18,17 @@ func Google() OAuthProvider {
 		Authorization:     "https://accounts.google.com/o/oauth2/v2/auth",
 		Token:             "https://oauth2.googleapis.com/token",
 		UserInfo:          "https://openidconnect.googleapis.com/v1/userinfo",
+		JWKS:              "https://www.googleapis.com/oauth2/v3/certs",
+		Revocation:        "https://oauth2.googleapis.com/revoke",
 		AllowEmailLinking: true,
 		ClientId:          os.Getenv("GOOGLE_CLIENT_ID"),
 		ClientSecret:      os.Getenv("GOOGLE_CLIENT_SECRET"),
+		Checks:            auth.Checks{PKCE: true, Nonce: true},
+		// Google only issues a refresh token for offline access.
+		AuthorizationQuery: map[string]string{"access_type": "offline"},
+		ValidateIssuer: func(iss string, claims jwt.MapClaims) bool {
+			// Google signs id_tokens with and without the scheme.
+			return iss == "https://accounts.google.com" || iss == "accounts.google.com"
+		},
 	}
 }
@@ -0,0 +1,197 @@
+package providers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultHTTPTimeout = 10 * time.Second
+	defaultHTTPRetries = 2
+	defaultRetryDelay  = 200 * time.Millisecond
+)
+
+// HTTPClientOptions configures the client providers use to call the
+// identity provider. The zero value gives sensible defaults.
+type HTTPClientOptions struct {
+	// Timeout bounds every request, retries included. Defaults to 10 seconds.
+	Timeout time.Duration
+	// Retries is how often a request is retried after a transient failure:
+	// a connection error or a 429, 502, 503 or 504 response. Requests other
+	// than GET and HEAD are only retried when they could not connect or got
+	// a 429 or 503. Defaults to 2, a negative value disables retries.
+	Retries int
+	// RetryDelay is the wait before the first retry, doubling after each
+	// attempt. Defaults to 200 milliseconds. A Retry-After header of the
+	// provider takes precedence.
+	RetryDelay time.Duration
+	// Proxy is the URL of the egress proxy. Defaults to the HTTPS_PROXY and
+	// NO_PROXY environment variables.
+	Proxy string
+	// CAFile is a PEM bundle of certificate authorities trusted in addition
+	// to the system ones, for identity providers behind a private CA.
+	CAFile string
+}
+
+// defaultHTTPClient is used by providers without an HTTPClient.
+var defaultHTTPClient, _ = NewHTTPClient(HTTPClientOptions{})
+
+// NewHTTPClient returns a client for provider calls configured by opts.
+func NewHTTPClient(opts HTTPClientOptions) (*http.Client, error) {
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultHTTPTimeout
+	}
+	if opts.Retries == 0 {
+		opts.Retries = defaultHTTPRetries
+	}
+	if opts.RetryDelay == 0 {
+		opts.RetryDelay = defaultRetryDelay
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if opts.Proxy != "" {
+		proxy, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	var roundTripper http.RoundTripper = transport
+	if opts.Retries > 0 {
+		roundTripper = &retryTransport{next: transport, retries: opts.Retries, delay: opts.RetryDelay}
+	}
+
+	return &http.Client{Timeout: opts.Timeout, Transport: roundTripper}, nil
+}
+
+// httpClient returns the client the provider makes its calls with.
+func (p OAuthProvider) httpClient() *http.Client {
+	if p.HTTPClient != nil {
+		return p.HTTPClient
+	}
+	return defaultHTTPClient
+}
+
+// retryTransport retries requests that failed for a transient reason.
+type retryTransport struct {
+	next    http.RoundTripper
+	retries int
+	delay   time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	delay := t.delay
+	for attempt := 0; ; attempt++ {
+		res, err := t.next.RoundTrip(req)
+		if attempt == t.retries || !shouldRetry(req, res, err) {
+			return res, err
+		}
+
+		wait := delay
+		if after, ok := retryAfter(res); ok {
+			wait = after
+		}
+		// Waiting past the deadline would only turn the response into a timeout.
+		if deadline, ok := req.Context().Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return res, err
+		}
+
+		// The body was consumed by the failed attempt.
+		if req.Body != nil {
+			if req.GetBody == nil {
+				return res, err
+			}
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return res, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		if res != nil {
+			res.Body.Close()
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+// shouldRetry reports whether a failed attempt may be repeated. Requests
+// with side effects, such as redeeming a single use code, are only retried
+// when they cannot have reached the provider (a dial error) or it refused
+// to handle them (429, 503). A gateway error may come after the provider
+// already handled the request.
+func shouldRetry(req *http.Request, res *http.Response, err error) bool {
+	idempotent := req.Method == http.MethodGet || req.Method == http.MethodHead
+
+	if err != nil {
+		if req.Context().Err() != nil {
+			return false
+		}
+
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return true
+		}
+		return idempotent
+	}
+
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+// retryAfter returns the wait requested by the Retry-After header of res,
+// given in seconds or as an HTTP date.
+func retryAfter(res *http.Response) (time.Duration, bool) {
+	if res == nil {
+		return 0, false
+	}
+
+	value := res.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,178 @@
+package providers
+
+import (
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPClientRetries(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "code=1" {
+			t.Errorf("expected the body to be sent on every attempt, got %q", body)
+		}
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	client, err := NewHTTPClient(HTTPClientOptions{RetryDelay: time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewHTTPClient() error = %v", err)
+	}
+
+	res, err := client.Post(server.URL, "application/x-www-form-urlencoded", strings.NewReader("code=1"))
+	if err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || attempts.Load() != 3 {
+		t.Errorf("expected success after 3 attempts, got %d after %d", res.StatusCode, attempts.Load())
+	}
+
+	attempts.Store(-10)
+	res, err = client.Post(server.URL, "application/x-www-form-urlencoded", strings.NewReader("code=1"))
+	if err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable || attempts.Load() != -7 {
+		t.Errorf("expected to give up after 2 retries, got %d after %d attempts", res.StatusCode, attempts.Load()+10)
+	}
+}
+
+func TestHTTPClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client, _ := NewHTTPClient(HTTPClientOptions{Timeout: 50 * time.Millisecond, Retries: -1})
+	start := time.Now()
+	if _, err := client.Get(server.URL); err == nil {
+		t.Fatalf("expected a hung provider to time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the request to be cut off after the timeout, took %v", elapsed)
+	}
+}
+
+func TestHTTPClientCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	if _, err := defaultHTTPClient.Get(server.URL); err == nil {
+		t.Fatalf("expected the test certificate to be untrusted by default")
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, pemBytes, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewHTTPClient(HTTPClientOptions{CAFile: caFile})
+	if err != nil {
+		t.Fatalf("NewHTTPClient() error = %v", err)
+	}
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("expected the bundled CA to be trusted, got %v", err)
+	}
+	res.Body.Close()
+
+	if _, err := NewHTTPClient(HTTPClientOptions{CAFile: filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Errorf("expected an error for a missing CA bundle")
+	}
+}
+
+func TestHTTPClientRetryPolicy(t *testing.T) {
+	tests := []struct {
+		method   string
+		status   int
+		attempts int32
+	}{
+		{http.MethodGet, http.StatusBadGateway, 3},
+		{http.MethodGet, http.StatusGatewayTimeout, 3},
+		{http.MethodPost, http.StatusBadGateway, 1},
+		{http.MethodPost, http.StatusGatewayTimeout, 1},
+		{http.MethodPost, http.StatusTooManyRequests, 3},
+		{http.MethodPost, http.StatusServiceUnavailable, 3},
+		{http.MethodPost, http.StatusInternalServerError, 1},
+	}
+
+	for _, tt := range tests {
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			w.WriteHeader(tt.status)
+		}))
+
+		client, _ := NewHTTPClient(HTTPClientOptions{RetryDelay: time.Millisecond})
+		req, _ := http.NewRequest(tt.method, server.URL, nil)
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+		res.Body.Close()
+		server.Close()
+
+		if attempts.Load() != tt.attempts {
+			t.Errorf("%s %d: expected %d attempts, got %d", tt.method, tt.status, tt.attempts, attempts.Load())
+		}
+	}
+}
+
+func TestHTTPClientRetryAfter(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.Header().Set("Retry-After", r.URL.Query().Get("retry_after"))
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	client, _ := NewHTTPClient(HTTPClientOptions{Timeout: 5 * time.Second, RetryDelay: time.Millisecond})
+	post := func(retryAfter string) *http.Response {
+		attempts.Store(0)
+		res, err := client.Post(server.URL+"?"+url.Values{"retry_after": {retryAfter}}.Encode(), "text/plain", strings.NewReader("x"))
+		if err != nil {
+			t.Fatalf("Post() error = %v", err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	for _, retryAfter := range []func() string{
+		func() string { return "1" },
+		// HTTP dates have a resolution of a second.
+		func() string { return time.Now().Add(2 * time.Second).UTC().Format(http.TimeFormat) },
+	} {
+		start := time.Now()
+		value := retryAfter()
+		if res := post(value); res.StatusCode != http.StatusOK || time.Since(start) < 900*time.Millisecond {
+			t.Errorf("Retry-After %s: expected to wait before retrying, got %d after %v", value, res.StatusCode, time.Since(start))
+		}
+	}
+
+	// A wait beyond the timeout returns the response instead of timing out.
+	if res := post("60"); res.StatusCode != http.StatusTooManyRequests || attempts.Load() != 1 {
+		t.Errorf("expected the 429 to be returned, got %d after %d attempts", res.StatusCode, attempts.Load())
+	}
+}
@@ -0,0 +1,84 @@
+package providers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// clockSkew is the tolerance applied to the time claims of an id_token.
+const clockSkew = 2 * time.Minute
+
+// verifyIdToken checks the signature of an id_token against the provider's
+// JWKS and validates its issuer, audience, lifetime and nonce.
+func (p OAuthProvider) verifyIdToken(ctx context.Context, idToken, nonce string) (jwt.MapClaims, error) {
+	if p.JWKS == "" {
+		return nil, fmt.Errorf("cannot verify id_token: provider %s has no JWKS endpoint", p.Id)
+	}
+
+	claims := jwt.MapClaims{}
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	_, err := parser.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := getKey(ctx, p.httpClient(), p.JWKS, kid)
+		if err != nil {
+			return nil, err
+		}
+
+		switch key.(type) {
+		case *rsa.PublicKey:
+			if _, ok := t.Method.(*jwt.SigningMethodRSA); ok {
+				return key, nil
+			}
+			if _, ok := t.Method.(*jwt.SigningMethodRSAPSS); ok {
+				return key, nil
+			}
+		case *ecdsa.PublicKey:
+			if _, ok := t.Method.(*jwt.SigningMethodECDSA); ok {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+
+	iss, _ := claims["iss"].(string)
+	if p.ValidateIssuer != nil {
+		if !p.ValidateIssuer(iss, claims) {
+			return nil, fmt.Errorf("invalid id_token: unexpected issuer %q", iss)
+		}
+	} else if iss != p.Issuer {
+		return nil, fmt.Errorf("invalid id_token: unexpected issuer %q", iss)
+	}
+
+	if !claims.VerifyAudience(p.ClientId, true) {
+		return nil, fmt.Errorf("invalid id_token: unexpected audience")
+	}
+
+	now := time.Now()
+	if !claims.VerifyExpiresAt(now.Add(-clockSkew).Unix(), true) {
+		return nil, fmt.Errorf("invalid id_token: token is expired")
+	}
+	if !claims.VerifyIssuedAt(now.Add(clockSkew).Unix(), true) {
+		return nil, fmt.Errorf("invalid id_token: token used before issued")
+	}
+	if !claims.VerifyNotBefore(now.Add(clockSkew).Unix(), false) {
+		return nil, fmt.Errorf("invalid id_token: token is not valid yet")
+	}
+
+	if p.Checks.Nonce {
+		claimed, _ := claims["nonce"].(string)
+		if nonce == "" || !hmac.Equal([]byte(claimed), []byte(nonce)) {
+			return nil, fmt.Errorf("invalid id_token: nonce mismatch")
+		}
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,149 @@
+package providers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"echo-server/internal/auth"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+type testKeyServer struct {
+	*httptest.Server
+	mu   sync.Mutex
+	keys map[string]*rsa.PrivateKey
+}
+
+func newTestKeyServer(t *testing.T) *testKeyServer {
+	ks := &testKeyServer{keys: map[string]*rsa.PrivateKey{}}
+	ks.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ks.mu.Lock()
+		defer ks.mu.Unlock()
+
+		keys := []map[string]string{}
+		for kid, key := range ks.keys {
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	t.Cleanup(ks.Close)
+	return ks
+}
+
+func (ks *testKeyServer) addKey(t *testing.T, kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+	ks.mu.Lock()
+	ks.keys[kid] = key
+	ks.mu.Unlock()
+}
+
+func (ks *testKeyServer) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	ks.mu.Lock()
+	key := ks.keys[kid]
+	ks.mu.Unlock()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("could not sign token: %v", err)
+	}
+	return signed
+}
+
+func testClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":   "https://idp.example.com",
+		"aud":   "client",
+		"sub":   "user-1",
+		"email": "user@example.com",
+		"nonce": "nonce",
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+}
+
+func TestVerifyIdToken(t *testing.T) {
+	ks := newTestKeyServer(t)
+	ks.addKey(t, "key-1")
+
+	p := OAuthProvider{
+		Id:       "test",
+		Issuer:   "https://idp.example.com",
+		ClientId: "client",
+		JWKS:     ks.URL,
+		Checks:   auth.Checks{Nonce: true},
+	}
+
+	profile, err := p.GetProfile(context.Background(), &auth.TokenSet{IdToken: ks.sign(t, "key-1", testClaims())}, "nonce")
+	if err != nil {
+		t.Fatalf("GetProfile() error = %v", err)
+	}
+	if profile.Id != "user-1" || profile.Email != "user@example.com" {
+		t.Errorf("Expected profile of user-1, got %+v", profile)
+	}
+
+	tests := map[string]func(jwt.MapClaims){
+		"issuer":   func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"audience": func(c jwt.MapClaims) { c["aud"] = "other" },
+		"expired":  func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"future":   func(c jwt.MapClaims) { c["iat"] = time.Now().Add(time.Hour).Unix() },
+		"nonce":    func(c jwt.MapClaims) { c["nonce"] = "replayed" },
+	}
+	for name, mutate := range tests {
+		claims := testClaims()
+		mutate(claims)
+		if _, err := p.GetProfile(context.Background(), &auth.TokenSet{IdToken: ks.sign(t, "key-1", claims)}, "nonce"); err == nil {
+			t.Errorf("Expected id_token with invalid %s to be rejected", name)
+		}
+	}
+
+	skewed := testClaims()
+	skewed["iat"] = time.Now().Add(30 * time.Second).Unix()
+	if _, err := p.GetProfile(context.Background(), &auth.TokenSet{IdToken: ks.sign(t, "key-1", skewed)}, "nonce"); err != nil {
+		t.Errorf("Expected small clock skew to be tolerated, got %v", err)
+	}
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	forged.Header["kid"] = "key-1"
+	signed, _ := forged.SignedString([]byte("secret"))
+	if _, err := p.GetProfile(context.Background(), &auth.TokenSet{IdToken: signed}, "nonce"); err == nil {
+		t.Errorf("Expected HS256 id_token to be rejected")
+	}
+}
+
+func TestVerifyIdTokenKeyRotation(t *testing.T) {
+	ks := newTestKeyServer(t)
+	ks.addKey(t, "key-1")
+
+	p := OAuthProvider{Id: "test", Issuer: "https://idp.example.com", ClientId: "client", JWKS: ks.URL}
+	if _, err := p.GetProfile(context.Background(), &auth.TokenSet{IdToken: ks.sign(t, "key-1", testClaims())}, ""); err != nil {
+		t.Fatalf("GetProfile() error = %v", err)
+	}
+
+	ks.addKey(t, "key-2")
+	getKeySet(ks.URL).fetchedAt = time.Now().Add(-jwksMinRefresh)
+
+	if _, err := p.GetProfile(context.Background(), &auth.TokenSet{IdToken: ks.sign(t, "key-2", testClaims())}, ""); err != nil {
+		t.Errorf("Expected rotated key to be fetched, got %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package providers
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// jwksMaxAge is how long a fetched key set is trusted before it is refreshed.
+	jwksMaxAge = time.Hour
+	// jwksMinRefresh limits how often an unknown kid can force a refetch, so
+	// tokens with made up key ids cannot hammer the provider.
+	jwksMinRefresh = time.Minute
+)
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type keySet struct {
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// keySets caches the key sets of every provider by JWKS URL. Providers are
+// plain values, so the cache lives outside of them.
+var (
+	keySetsMu sync.Mutex
+	keySets   = map[string]*keySet{}
+)
+
+func getKeySet(uri string) *keySet {
+	keySetsMu.Lock()
+	defer keySetsMu.Unlock()
+
+	set, ok := keySets[uri]
+	if !ok {
+		set = &keySet{}
+		keySets[uri] = set
+	}
+	return set
+}
+
+// getKey returns the key with kid from the JWKS at uri. The key set is
+// refetched when it is stale or does not know kid, to follow key rotation.
+func getKey(ctx context.Context, client *http.Client, uri, kid string) (crypto.PublicKey, error) {
+	set := getKeySet(uri)
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	key, ok := set.keys[kid]
+	age := time.Since(set.fetchedAt)
+	if ok && age < jwksMaxAge {
+		return key, nil
+	}
+
+	if !ok && age < jwksMinRefresh {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	keys, err := fetchKeys(ctx, client, uri)
+	if err != nil {
+		if ok {
+			// Keep using the known key while the provider is unreachable.
+			return key, nil
+		}
+		return nil, err
+	}
+	set.keys = keys
+	set.fetchedAt = time.Now()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func fetchKeys(ctx context.Context, client *http.Client, uri string) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching jwks: unexpected status %d", res.StatusCode)
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			// Skip key types we don't support instead of failing the whole set.
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(bytes), nil
+}
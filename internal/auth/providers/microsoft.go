@@ -0,0 +1,89 @@
+package providers
+
+import (
+	"context"
+	"echo-server/internal/auth"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+// consumersTenant is the tenant of personal Microsoft accounts.
+const consumersTenant = "9188040d-6c67-4c5b-b112-36a304b66dad"
+
+// MicrosoftEntraID returns a provider for a single tenant by its id, or for many when
+// tenant is "common", "organizations" or "consumers". An empty tenant
+// defaults to "common". It fails for a tenant domain such as
+// contoso.onmicrosoft.com, as id_tokens only carry the tenant id.
+func MicrosoftEntraID(tenant string) (OAuthProvider, error) {
+	switch tenant {
+	case "":
+		tenant = "common"
+	case "common", "organizations", "consumers":
+	default:
+		id, err := uuid.Parse(tenant)
+		if err != nil {
+			return OAuthProvider{}, fmt.Errorf("Microsoft Entra ID tenant %q is not a tenant id", tenant)
+		}
+		// The tid claim is in the canonical lower case form.
+		tenant = id.String()
+	}
+	base := "https://login.microsoftonline.com/" + tenant
+
+	return OAuthProvider{
+		Id:             "microsoft-entra-id",
+		Name:           "Microsoft Entra ID",
+		Issuer:         base + "/v2.0",
+		Type:           "oidc",
+		Image:          "https://authjs.dev/img/providers/microsoft-entra-id.svg",
+		Scopes:         []string{"openid", "profile", "email"},
+		Authorization:  base + "/oauth2/v2.0/authorize",
+		Token:          base + "/oauth2/v2.0/token",
+		UserInfo:       "https://graph.microsoft.com/oidc/userinfo",
+		JWKS:           base + "/discovery/v2.0/keys",
+		ClientId:       os.Getenv("MICROSOFT_ENTRA_ID_CLIENT_ID"),
+		ClientSecret:   os.Getenv("MICROSOFT_ENTRA_ID_CLIENT_SECRET"),
+		Checks:         auth.Checks{PKCE: true, Nonce: true},
+		ValidateIssuer: entraIssuerValidator(tenant),
+		Profile:        entraProfile,
+	}, nil
+}
+
+// entraIssuerValidator checks the iss claim of an id_token. Tokens of the
+// multi-tenant endpoints are issued by the tenant of the user, so the issuer
+// is derived from the tid claim and the tenant is checked against the
+// audience the endpoint allows.
+func entraIssuerValidator(tenant string) func(iss string, claims jwt.MapClaims) bool {
+	return func(iss string, claims jwt.MapClaims) bool {
+		tid, _ := claims["tid"].(string)
+		if tid == "" || iss != fmt.Sprintf("https://login.microsoftonline.com/%s/v2.0", tid) {
+			return false
+		}
+
+		switch tenant {
+		case "common":
+			return true
+		case "organizations":
+			return tid != consumersTenant
+		case "consumers":
+			return tid == consumersTenant
+		default:
+			return tid == tenant
+		}
+	}
+}
+
+// entraProfile maps the id_token claims. Entra ID does not verify the email
+// claim, which tenant admins can set freely, so it is never trusted for
+// account linking.
+func entraProfile(ctx context.Context, p OAuthProvider, raw map[string]interface{}, token auth.TokenSet) (auth.Profile, error) {
+	profile := StandardProfile(raw)
+	if profile.Email == "" {
+		profile.Email = claimString(raw, "preferred_username")
+	}
+	profile.EmailVerified = false
+
+	return profile, nil
+}
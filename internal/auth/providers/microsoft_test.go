@@ -0,0 +1,91 @@
+package providers
+
+import (
+	"context"
+	"echo-server/internal/auth"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	contosoTenant  = "72f988bf-86f1-41af-91ab-2d7cd011db47"
+	fabrikamTenant = "f8cdef31-a31e-4b4a-93e4-5f571e91255a"
+)
+
+func entraClaims(tid string) jwt.MapClaims {
+	return jwt.MapClaims{"tid": tid}
+}
+
+func TestMicrosoftEntraIDIssuer(t *testing.T) {
+	issuer := func(tid string) string {
+		return "https://login.microsoftonline.com/" + tid + "/v2.0"
+	}
+
+	tests := []struct {
+		tenant string
+		tid    string
+		iss    string
+		valid  bool
+	}{
+		{contosoTenant, contosoTenant, issuer(contosoTenant), true},
+		{contosoTenant, fabrikamTenant, issuer(fabrikamTenant), false},
+		{"common", fabrikamTenant, issuer(fabrikamTenant), true},
+		{"common", fabrikamTenant, issuer(contosoTenant), false},
+		{"organizations", consumersTenant, issuer(consumersTenant), false},
+		{"consumers", consumersTenant, issuer(consumersTenant), true},
+		{"consumers", contosoTenant, issuer(contosoTenant), false},
+	}
+
+	for _, tt := range tests {
+		p, err := MicrosoftEntraID(tt.tenant)
+		if err != nil {
+			t.Fatalf("MicrosoftEntraID(%q) error = %v", tt.tenant, err)
+		}
+		if valid := p.ValidateIssuer(tt.iss, entraClaims(tt.tid)); valid != tt.valid {
+			t.Errorf("tenant %s: expected issuer %s of tid %s to be valid = %v", tt.tenant, tt.iss, tt.tid, tt.valid)
+		}
+	}
+}
+
+func TestMicrosoftEntraIDTenant(t *testing.T) {
+	p, err := MicrosoftEntraID(strings.ToUpper(contosoTenant))
+	if err != nil {
+		t.Fatalf("MicrosoftEntraID() error = %v", err)
+	}
+	if !p.ValidateIssuer("https://login.microsoftonline.com/"+contosoTenant+"/v2.0", entraClaims(contosoTenant)) {
+		t.Errorf("Expected a tenant id in upper case to match the tid claim")
+	}
+
+	if _, err := MicrosoftEntraID("contoso.onmicrosoft.com"); err == nil {
+		t.Errorf("Expected a tenant domain to be rejected")
+	}
+}
+
+// Claims recorded from a v2.0 id_token of a work account.
+func TestMicrosoftEntraIDProfile(t *testing.T) {
+	p, err := MicrosoftEntraID("")
+	if err != nil {
+		t.Fatalf("MicrosoftEntraID() error = %v", err)
+	}
+
+	profile, err := p.Profile(context.Background(), p, map[string]interface{}{
+		"sub":                "AAAAAAAAAAAAAAAAAAAAAIkzqFVrSaSaFHy782bbtaQ",
+		"name":               "Abe Lincoln",
+		"preferred_username": "abeli@microsoft.com",
+		"oid":                "00000000-0000-0000-66f3-3332eca7ea81",
+		"tid":                contosoTenant,
+		"email_verified":     true,
+	}, auth.TokenSet{})
+	if err != nil {
+		t.Fatalf("Profile() error = %v", err)
+	}
+
+	if profile.Id != "AAAAAAAAAAAAAAAAAAAAAIkzqFVrSaSaFHy782bbtaQ" || profile.Email != "abeli@microsoft.com" {
+		t.Errorf("Expected profile of Abe Lincoln, got %+v", profile)
+	}
+	if profile.EmailVerified {
+		t.Errorf("Expected Entra ID email to never be trusted as verified")
+	}
+}
@@ -1,12 +1,15 @@
 package providers
 
 import (
+	"context"
 	"echo-server/internal/auth"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 )
@@ -21,9 +24,33 @@ type OAuthProvider struct {
 	Authorization     string
 	Token             string
 	UserInfo          string
+	JWKS              string
+	Revocation        string
 	ClientId          string
 	ClientSecret      string
 	AllowEmailLinking bool
+	Checks            auth.Checks
+	// ResponseMode is sent as response_mode, e.g. "form_post".
+	ResponseMode string
+	// AuthorizationQuery holds extra parameters of the authorization request.
+	AuthorizationQuery map[string]string
+	// GenerateClientSecret replaces ClientSecret for providers that expect a
+	// freshly signed secret on every token request.
+	GenerateClientSecret func() (string, error)
+	// Profile maps the id_token claims or UserInfo response to a profile.
+	// It receives the provider to make further API calls with GetJSON.
+	// Defaults to StandardProfile.
+	Profile func(ctx context.Context, p OAuthProvider, raw map[string]interface{}, token auth.TokenSet) (auth.Profile, error)
+	// ValidateIssuer replaces the check of the id_token iss claim against
+	// Issuer, for providers that issue tokens from more than one issuer.
+	ValidateIssuer func(iss string, claims jwt.MapClaims) bool
+	// HTTPClient makes the calls to the provider. Defaults to a client with
+	// a timeout and retries, see NewHTTPClient.
+	HTTPClient *http.Client
+
+	// discovery is the issuer whose discovery document the endpoints are
+	// read from, for providers created by OIDC.
+	discovery string
 }
 
 // GetId implements auth.Provider.
@@ -47,110 +74,239 @@ func (p OAuthProvider) GetPublicData() auth.ProviderData {
 	}
 }
 
+// AllowsEmailLinking implements auth.Provider.
+func (p OAuthProvider) AllowsEmailLinking() bool {
+	return p.AllowEmailLinking
+}
+
+// GetChecks implements auth.Provider.
+func (p OAuthProvider) GetChecks() auth.Checks {
+	return p.Checks
+}
+
 // GetRedirectURL implements auth.Provider.
-func (p OAuthProvider) GetRedirectURL(base string) string {
+func (p OAuthProvider) GetRedirectURL(params auth.AuthorizationParams) string {
+	p = p.discovered(context.Background())
 	authUrl := p.Authorization
 	clientId := p.ClientId
 	scopes := strings.Join(p.Scopes, " ")
 
-	state := auth.GenerateHMACToken(16)
-
-	fmt.Println(state, "")
-
 	query := url.Values{}
 	query.Set("client_id", clientId)
-	query.Set("redirect_uri", base)
+	query.Set("redirect_uri", params.RedirectURI)
 	query.Set("response_type", "code")
 	query.Set("scope", scopes)
-	query.Set("state", state)
+	query.Set("state", params.State)
+
+	if params.CodeVerifier != "" {
+		query.Set("code_challenge", auth.CodeChallengeS256(params.CodeVerifier))
+		query.Set("code_challenge_method", "S256")
+	}
+
+	if params.Nonce != "" {
+		query.Set("nonce", params.Nonce)
+	}
+
+	if p.ResponseMode != "" {
+		query.Set("response_mode", p.ResponseMode)
+	}
+
+	for key, value := range p.AuthorizationQuery {
+		query.Set(key, value)
+	}
 
 	return fmt.Sprintf("%s?%s", authUrl, query.Encode())
 }
 
 // HandleCallback implements auth.Provider.
-func (p OAuthProvider) HandleCallback(req *http.Request) (auth.Profile, auth.TokenSet, error) {
-	state := req.URL.Query().Get("state")
-	code := req.URL.Query().Get("code")
-	fmt.Println(state, code)
-
+func (p OAuthProvider) HandleCallback(ctx context.Context, req *http.Request, params auth.AuthorizationParams) (auth.Profile, auth.TokenSet, error) {
 	fail := func(err error) (auth.Profile, auth.TokenSet, error) {
 		return auth.Profile{}, auth.TokenSet{}, err
 	}
 
-	if !auth.VerifyHMACToken(state) {
-		return fail(fmt.Errorf("invalid state"))
+	// The provider redirects back with an error instead of a code when the
+	// user declines or the request is invalid.
+	if errorCode := req.FormValue("error"); errorCode != "" {
+		err := fmt.Errorf("%s: %s", errorCode, req.FormValue("error_description"))
+		if errorCode == "access_denied" {
+			return fail(auth.NewSignInError(auth.ErrorAccessDenied, err))
+		}
+		return fail(auth.NewSignInError(auth.ErrorProvider, err))
 	}
 
-	tokenSet, err := p.Exchange(code)
-	if err != nil {
-		return fail(err)
+	code := req.FormValue("code")
+	if code == "" {
+		return fail(auth.NewSignInError(auth.ErrorProvider, fmt.Errorf("missing code")))
 	}
 
-	profile, err := p.GetProfile(&tokenSet)
+	tokenSet, err := p.Exchange(ctx, code, params)
 	if err != nil {
-		return fail(err)
+		return fail(auth.NewSignInError(auth.ErrorExchangeFailed, err))
 	}
 
-	if p.AllowEmailLinking {
-		profile.EmailVerified = true
+	profile, err := p.GetProfile(ctx, &tokenSet, params.Nonce)
+	if err != nil {
+		return fail(auth.NewSignInError(auth.ErrorInvalidProfile, err))
 	}
 
 	return profile, tokenSet, nil
 }
 
-func (p OAuthProvider) Exchange(code string) (auth.TokenSet, error) {
+// Exchange trades an authorization code for tokens. The redirect_uri must
+// be the one the authorization request was made with.
+func (p OAuthProvider) Exchange(ctx context.Context, code string, params auth.AuthorizationParams) (auth.TokenSet, error) {
 	query := url.Values{}
 	query.Set("code", code)
-	query.Set("client_id", p.ClientId)
-	query.Set("client_secret", p.ClientSecret)
-	query.Set("redirect_uri", "http://localhost:8080/auth/callback/"+p.Id)
+	query.Set("redirect_uri", params.RedirectURI)
 	query.Set("grant_type", "authorization_code")
+	if params.CodeVerifier != "" {
+		query.Set("code_verifier", params.CodeVerifier)
+	}
+
+	return p.requestToken(ctx, query)
+}
+
+// Refresh uses a refresh token to obtain a new access token. Providers that
+// rotate refresh tokens return the new one in the token set.
+func (p OAuthProvider) Refresh(ctx context.Context, refreshToken string) (auth.TokenSet, error) {
+	query := url.Values{}
+	query.Set("refresh_token", refreshToken)
+	query.Set("grant_type", "refresh_token")
 
+	return p.requestToken(ctx, query)
+}
+
+// tokenResponse is the body of a token endpoint response, successful or not.
+type tokenResponse struct {
+	auth.TokenSet
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// requestToken authenticates the client at the token endpoint and performs
+// the grant described by query.
+func (p OAuthProvider) requestToken(ctx context.Context, query url.Values) (auth.TokenSet, error) {
 	fail := func(err error) (auth.TokenSet, error) {
 		return auth.TokenSet{}, err
 	}
 
-	req, err := http.NewRequest("POST", p.Token, strings.NewReader(query.Encode()))
+	p = p.discovered(ctx)
+	res, err := p.postForm(ctx, p.Token, query)
 	if err != nil {
 		return fail(err)
 	}
+	defer res.Body.Close()
+
+	var body tokenResponse
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return fail(fmt.Errorf("%s: unexpected status %d", p.Token, res.StatusCode))
+	}
 
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// Some providers, GitHub among them, report errors with a 200 status.
+	if body.Error != "" {
+		if body.ErrorDescription != "" {
+			return fail(fmt.Errorf("%s: %s", body.Error, body.ErrorDescription))
+		}
+		return fail(fmt.Errorf("%s", body.Error))
+	}
+	if res.StatusCode != http.StatusOK {
+		return fail(fmt.Errorf("%s: unexpected status %d", p.Token, res.StatusCode))
+	}
+
+	tokenSet := body.TokenSet
+	if tokenSet.ExpiresAt == 0 && body.ExpiresIn > 0 {
+		tokenSet.ExpiresAt = int(time.Now().Unix()) + body.ExpiresIn
+	}
+
+	return tokenSet, nil
+}
+
+// Revoke invalidates a token at the provider, as described by RFC 7009.
+// tokenTypeHint is "access_token" or "refresh_token". Providers without a
+// revocation endpoint do nothing.
+func (p OAuthProvider) Revoke(ctx context.Context, token, tokenTypeHint string) error {
+	if p.Revocation == "" {
+		return nil
+	}
 
-	res, err := http.DefaultClient.Do(req)
+	query := url.Values{}
+	query.Set("token", token)
+	query.Set("token_type_hint", tokenTypeHint)
+
+	res, err := p.postForm(ctx, p.Revocation, query)
 	if err != nil {
-		return fail(err)
+		return err
 	}
 	defer res.Body.Close()
 
-	var tokenSet auth.TokenSet
-	if err := json.NewDecoder(res.Body).Decode(&tokenSet); err != nil {
-		return fail(err)
+	// Revoking an invalid or already revoked token also succeeds.
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected status %d", p.Revocation, res.StatusCode)
 	}
+	return nil
+}
 
-	return tokenSet, nil
+// postForm authenticates the client and posts query to an endpoint.
+func (p OAuthProvider) postForm(ctx context.Context, endpoint string, query url.Values) (*http.Response, error) {
+	clientSecret := p.ClientSecret
+	if p.GenerateClientSecret != nil {
+		secret, err := p.GenerateClientSecret()
+		if err != nil {
+			return nil, err
+		}
+		clientSecret = secret
+	}
+	query.Set("client_id", p.ClientId)
+	query.Set("client_secret", clientSecret)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(query.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	return p.httpClient().Do(req)
 }
 
-func (p OAuthProvider) GetProfile(token *auth.TokenSet) (auth.Profile, error) {
+// GetProfile returns the profile of the signed in user. It is read from the
+// verified id_token when the provider issued one, and from the UserInfo
+// endpoint otherwise.
+func (p OAuthProvider) GetProfile(ctx context.Context, token *auth.TokenSet, nonce string) (auth.Profile, error) {
 	fail := func(err error) (auth.Profile, error) {
 		return auth.Profile{}, err
 	}
 
-	if token.IdToken == "" {
+	p = p.discovered(ctx)
+	var raw map[string]interface{}
+	switch {
+	case token.IdToken != "":
+		claims, err := p.verifyIdToken(ctx, token.IdToken, nonce)
+		if err != nil {
+			return fail(err)
+		}
+		raw = claims
+	case p.UserInfo != "":
+		if err := p.GetJSON(ctx, p.UserInfo, token.AccessToken, &raw); err != nil {
+			return fail(err)
+		}
+	default:
 		return fail(fmt.Errorf("missing id_token"))
 	}
 
-	parsed, _, err := new(jwt.Parser).ParseUnverified(token.IdToken, jwt.MapClaims{})
-	if err != nil {
-		return fail(err)
+	if p.Profile != nil {
+		return p.Profile(ctx, p, raw, *token)
 	}
 
-	claims := parsed.Claims.(jwt.MapClaims)
+	return StandardProfile(raw), nil
+}
 
+// StandardProfile maps OpenID Connect standard claims to a profile.
+func StandardProfile(claims map[string]interface{}) auth.Profile {
 	mapClaimsToProfile := func(key string, target *string) {
-		if val, ok := claims[key]; ok {
-			*target = val.(string)
-		}
+		*target = claimString(claims, key)
 	}
 
 	var profile auth.Profile
@@ -173,5 +329,52 @@ func (p OAuthProvider) GetProfile(token *auth.TokenSet) (auth.Profile, error) {
 	mapClaimsToProfile("address", &profile.Address)
 	mapClaimsToProfile("updated_at", &profile.UpdatedAt)
 
-	return profile, nil
+	switch val := claims["email_verified"].(type) {
+	case bool:
+		profile.EmailVerified = val
+	case string:
+		// Some providers serialize the claim as a string.
+		profile.EmailVerified = val == "true"
+	}
+
+	return profile
+}
+
+// GetJSON calls a provider API on behalf of the user and decodes the response into target.
+func (p OAuthProvider) GetJSON(ctx context.Context, endpoint, accessToken string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected status %d", endpoint, res.StatusCode)
+	}
+
+	decoder := json.NewDecoder(res.Body)
+	decoder.UseNumber()
+	return decoder.Decode(target)
+}
+
+// claimString returns a claim as a string, formatting numeric ids as returned by most APIs.
+func claimString(claims map[string]interface{}, key string) string {
+	switch val := claims[key].(type) {
+	case string:
+		return val
+	case json.Number:
+		return val.String()
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		return ""
+	}
 }
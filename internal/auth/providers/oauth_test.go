@@ -0,0 +1,196 @@
+package providers
+
+import (
+	"context"
+	"echo-server/internal/auth"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetRedirectURLWithPKCE(t *testing.T) {
+	p := OAuthProvider{
+		Id:            "test",
+		Authorization: "https://idp.example.com/authorize",
+		ClientId:      "client",
+		Scopes:        []string{"openid"},
+		Checks:        auth.Checks{PKCE: true},
+	}
+
+	verifier := auth.GenerateCodeVerifier()
+	redirect, err := url.Parse(p.GetRedirectURL(auth.AuthorizationParams{
+		RedirectURI:  "http://localhost/auth/callback/test",
+		CodeVerifier: verifier,
+	}))
+	if err != nil {
+		t.Fatalf("GetRedirectURL() returned an invalid URL: %v", err)
+	}
+
+	query := redirect.Query()
+	if query.Get("code_challenge") != auth.CodeChallengeS256(verifier) {
+		t.Errorf("Expected code_challenge for verifier, got %s", query.Get("code_challenge"))
+	}
+	if query.Get("code_challenge_method") != "S256" {
+		t.Errorf("Expected code_challenge_method S256, got %s", query.Get("code_challenge_method"))
+	}
+}
+
+func TestExchange(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "token"})
+	}))
+	defer server.Close()
+
+	p := OAuthProvider{Id: "test", Token: server.URL, ClientId: "client"}
+	tokenSet, err := p.Exchange(context.Background(), "code", auth.AuthorizationParams{
+		RedirectURI:  "https://example.com/auth/callback/test",
+		CodeVerifier: "verifier",
+	})
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+
+	if tokenSet.AccessToken != "token" {
+		t.Errorf("Expected access token, got %s", tokenSet.AccessToken)
+	}
+	if form.Get("code_verifier") != "verifier" {
+		t.Errorf("Expected code_verifier to be sent, got %s", form.Get("code_verifier"))
+	}
+	if form.Get("redirect_uri") != "https://example.com/auth/callback/test" {
+		t.Errorf("Expected redirect_uri of the authorization request, got %s", form.Get("redirect_uri"))
+	}
+}
+
+func TestHandleCallbackErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "invalid_grant"}`))
+	}))
+	defer server.Close()
+
+	p := OAuthProvider{Id: "test", Token: server.URL, ClientId: "client"}
+	tests := []struct {
+		query string
+		want  auth.ErrorCode
+	}{
+		{"error=access_denied&error_description=The+user+declined", auth.ErrorAccessDenied},
+		{"error=server_error", auth.ErrorProvider},
+		{"", auth.ErrorProvider},
+		{"code=expired", auth.ErrorExchangeFailed},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/auth/callback/test?"+tt.query, nil)
+		_, _, err := p.HandleCallback(context.Background(), req, auth.AuthorizationParams{})
+
+		var signInErr *auth.SignInError
+		if !errors.As(err, &signInErr) || signInErr.Code != tt.want {
+			t.Errorf("%q: expected %s, got %v", tt.query, tt.want, err)
+		}
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("refresh_token") != "refresh" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "invalid_grant", "error_description": "Token has been expired or revoked."}`))
+			return
+		}
+		w.Write([]byte(`{"access_token": "renewed", "expires_in": 3600}`))
+	}))
+	defer server.Close()
+
+	p := OAuthProvider{Id: "test", Token: server.URL, ClientId: "client"}
+	tokenSet, err := p.Refresh(context.Background(), "refresh")
+	if err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	if tokenSet.AccessToken != "renewed" {
+		t.Errorf("Expected renewed access token, got %s", tokenSet.AccessToken)
+	}
+	if expiresIn := int64(tokenSet.ExpiresAt) - time.Now().Unix(); expiresIn < 3590 || expiresIn > 3600 {
+		t.Errorf("Expected expires_at to be derived from expires_in, got %d", tokenSet.ExpiresAt)
+	}
+
+	if _, err := p.Refresh(context.Background(), "revoked"); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("Expected the invalid_grant error, got %v", err)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		if form.Get("client_secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	p := OAuthProvider{Id: "test", Revocation: server.URL, ClientId: "client", ClientSecret: "secret"}
+	if err := p.Revoke(context.Background(), "refresh", "refresh_token"); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if form.Get("token") != "refresh" || form.Get("token_type_hint") != "refresh_token" {
+		t.Errorf("Expected the token and its type to be sent, got %v", form)
+	}
+
+	p.ClientSecret = "wrong"
+	if err := p.Revoke(context.Background(), "refresh", "refresh_token"); err == nil {
+		t.Errorf("Expected an error when the provider rejects the request")
+	}
+
+	p.Revocation = ""
+	if err := p.Revoke(context.Background(), "refresh", "refresh_token"); err != nil {
+		t.Errorf("Expected providers without a revocation endpoint to do nothing, got %v", err)
+	}
+}
+
+func TestGetProfileFromUserInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"id": 9007199254740993, "login": "octo", "email": "octo@example.com"}`))
+	}))
+	defer server.Close()
+
+	p := OAuthProvider{
+		Id:       "test",
+		UserInfo: server.URL,
+		Profile: func(ctx context.Context, p OAuthProvider, raw map[string]interface{}, token auth.TokenSet) (auth.Profile, error) {
+			return auth.Profile{
+				Id:       claimString(raw, "id"),
+				Nickname: claimString(raw, "login"),
+				Email:    claimString(raw, "email"),
+			}, nil
+		},
+	}
+
+	profile, err := p.GetProfile(context.Background(), &auth.TokenSet{AccessToken: "token"}, "")
+	if err != nil {
+		t.Fatalf("GetProfile() error = %v", err)
+	}
+
+	expected := auth.Profile{Id: "9007199254740993", Nickname: "octo", Email: "octo@example.com"}
+	if profile != expected {
+		t.Errorf("Expected %+v, got %+v", expected, profile)
+	}
+
+	if _, err := p.GetProfile(context.Background(), &auth.TokenSet{AccessToken: "expired"}, ""); err == nil {
+		t.Errorf("Expected rejected access token to fail")
+	}
+}
@@ -0,0 +1,187 @@
+package providers
+
+import (
+	"context"
+	"echo-server/internal/auth"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// discoveryMaxAge is how long a discovery document is reused before it is fetched again.
+const discoveryMaxAge = 24 * time.Hour
+
+type OIDCOptions struct {
+	// Id identifies the provider in routes such as /auth/login/:provider. Defaults to "oidc".
+	Id string
+	// Name is shown to users. Defaults to the issuer host.
+	Name  string
+	Image string
+	// Scopes requested at sign in. Defaults to the standard openid, profile
+	// and email scopes the provider supports.
+	Scopes            []string
+	AllowEmailLinking bool
+	// HTTPClient fetches the discovery document and makes the calls of the
+	// provider. Defaults to a client with a timeout and retries.
+	HTTPClient *http.Client
+}
+
+// DiscoveryDocument is the OpenID Provider Metadata served at /.well-known/openid-configuration.
+type DiscoveryDocument struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	UserInfoEndpoint              string   `json:"userinfo_endpoint"`
+	JWKSURI                       string   `json:"jwks_uri"`
+	ScopesSupported               []string `json:"scopes_supported"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+}
+
+type cachedDiscovery struct {
+	doc       DiscoveryDocument
+	fetchedAt time.Time
+}
+
+// discoveryCache holds the discovery documents by issuer. Like the key sets,
+// it lives outside of the providers, which are plain values.
+var (
+	discoveryMu    sync.Mutex
+	discoveryCache = map[string]cachedDiscovery{}
+)
+
+// OIDC returns a provider configured from the discovery document of issuer,
+// for identity providers such as Keycloak, Okta, Authentik or Dex. The
+// provider reads its endpoints through the discovery cache, so it follows
+// changes to the document once the cached one is a day old.
+func OIDC(issuer, clientId, clientSecret string, opts OIDCOptions) (OAuthProvider, error) {
+	doc, err := Discover(context.Background(), opts.HTTPClient, issuer)
+	if err != nil {
+		return OAuthProvider{}, err
+	}
+
+	if opts.Id == "" {
+		opts.Id = "oidc"
+	}
+	if opts.Name == "" {
+		if u, err := url.Parse(doc.Issuer); err == nil {
+			opts.Name = u.Host
+		}
+	}
+	if len(opts.Scopes) == 0 {
+		for _, scope := range []string{"openid", "profile", "email"} {
+			if len(doc.ScopesSupported) == 0 || slices.Contains(doc.ScopesSupported, scope) {
+				opts.Scopes = append(opts.Scopes, scope)
+			}
+		}
+	}
+
+	return OAuthProvider{
+		Id:                opts.Id,
+		Name:              opts.Name,
+		Issuer:            doc.Issuer,
+		Type:              "oidc",
+		Image:             opts.Image,
+		Scopes:            opts.Scopes,
+		Authorization:     doc.AuthorizationEndpoint,
+		Token:             doc.TokenEndpoint,
+		UserInfo:          doc.UserInfoEndpoint,
+		JWKS:              doc.JWKSURI,
+		ClientId:          clientId,
+		ClientSecret:      clientSecret,
+		AllowEmailLinking: opts.AllowEmailLinking,
+		Checks: auth.Checks{
+			PKCE:  slices.Contains(doc.CodeChallengeMethodsSupported, "S256"),
+			Nonce: true,
+		},
+		HTTPClient: opts.HTTPClient,
+		discovery:  doc.Issuer,
+	}, nil
+}
+
+// Discover returns the discovery document of issuer, fetching it with client
+// when it is not cached or older than a day. A nil client uses the default one.
+func Discover(ctx context.Context, client *http.Client, issuer string) (DiscoveryDocument, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+
+	discoveryMu.Lock()
+	defer discoveryMu.Unlock()
+
+	cached, ok := discoveryCache[issuer]
+	if ok && time.Since(cached.fetchedAt) < discoveryMaxAge {
+		return cached.doc, nil
+	}
+
+	doc, err := fetchDiscovery(ctx, client, issuer)
+	if err != nil {
+		if ok {
+			// Keep using the known document while the issuer is unreachable.
+			return cached.doc, nil
+		}
+		return DiscoveryDocument{}, err
+	}
+
+	discoveryCache[issuer] = cachedDiscovery{doc: doc, fetchedAt: time.Now()}
+	return doc, nil
+}
+
+// discovered returns p with the endpoints of the cached discovery document,
+// for providers created by OIDC. Other providers are returned as they are.
+func (p OAuthProvider) discovered(ctx context.Context) OAuthProvider {
+	if p.discovery == "" {
+		return p
+	}
+
+	doc, err := Discover(ctx, p.HTTPClient, p.discovery)
+	if err != nil {
+		// The endpoints p was created with are still the best we know.
+		return p
+	}
+
+	p.Authorization = doc.AuthorizationEndpoint
+	p.Token = doc.TokenEndpoint
+	p.UserInfo = doc.UserInfoEndpoint
+	p.JWKS = doc.JWKSURI
+	return p
+}
+
+func fetchDiscovery(ctx context.Context, client *http.Client, issuer string) (DiscoveryDocument, error) {
+	if client == nil {
+		client = defaultHTTPClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return DiscoveryDocument{}, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return DiscoveryDocument{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return DiscoveryDocument{}, fmt.Errorf("fetching discovery document: unexpected status %d", res.StatusCode)
+	}
+
+	var doc DiscoveryDocument
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		return DiscoveryDocument{}, err
+	}
+
+	// The issuer in the document must match the one it was fetched for,
+	// otherwise id_tokens would be validated against the wrong issuer.
+	if strings.TrimSuffix(doc.Issuer, "/") != issuer {
+		return DiscoveryDocument{}, fmt.Errorf("discovery document issuer %q does not match %q", doc.Issuer, issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return DiscoveryDocument{}, fmt.Errorf("discovery document of %s is missing required endpoints", issuer)
+	}
+
+	return doc, nil
+}
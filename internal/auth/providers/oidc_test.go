@@ -0,0 +1,126 @@
+package providers
+
+import (
+	"echo-server/internal/auth"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func newDiscoveryServer(t *testing.T, issuer func(base string) string) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/openid-configuration" {
+			http.NotFound(w, r)
+			return
+		}
+		requests.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                           issuer(server.URL),
+			"authorization_endpoint":           server.URL + "/authorize",
+			"token_endpoint":                   server.URL + "/token",
+			"userinfo_endpoint":                server.URL + "/userinfo",
+			"jwks_uri":                         server.URL + "/jwks",
+			"scopes_supported":                 []string{"openid", "email", "offline_access"},
+			"code_challenge_methods_supported": []string{"plain", "S256"},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestOIDC(t *testing.T) {
+	server, requests := newDiscoveryServer(t, func(base string) string { return base })
+
+	p, err := OIDC(server.URL, "client", "secret", OIDCOptions{Id: "keycloak"})
+	if err != nil {
+		t.Fatalf("OIDC() error = %v", err)
+	}
+
+	if p.Id != "keycloak" || p.Issuer != server.URL {
+		t.Errorf("Expected keycloak provider for %s, got %s for %s", server.URL, p.Id, p.Issuer)
+	}
+	if p.Authorization != server.URL+"/authorize" || p.Token != server.URL+"/token" ||
+		p.UserInfo != server.URL+"/userinfo" || p.JWKS != server.URL+"/jwks" {
+		t.Errorf("Expected endpoints from discovery document, got %+v", p)
+	}
+	if !reflect.DeepEqual(p.Scopes, []string{"openid", "email"}) {
+		t.Errorf("Expected supported default scopes, got %v", p.Scopes)
+	}
+	if !p.Checks.PKCE || !p.Checks.Nonce {
+		t.Errorf("Expected PKCE and nonce checks, got %+v", p.Checks)
+	}
+
+	if _, err := OIDC(server.URL+"/", "client", "secret", OIDCOptions{}); err != nil {
+		t.Fatalf("OIDC() error = %v", err)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("Expected discovery document to be cached, got %d requests", requests.Load())
+	}
+}
+
+func TestOIDCRefreshesDiscovery(t *testing.T) {
+	var authorize atomic.Value
+	authorize.Store("/authorize")
+	var failing atomic.Bool
+	var requests atomic.Int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + authorize.Load().(string),
+			"token_endpoint":         server.URL + "/token",
+			"jwks_uri":               server.URL + "/jwks",
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	p, err := OIDC(server.URL, "client", "secret", OIDCOptions{})
+	if err != nil {
+		t.Fatalf("OIDC() error = %v", err)
+	}
+	age := func() {
+		discoveryMu.Lock()
+		defer discoveryMu.Unlock()
+		cached := discoveryCache[server.URL]
+		cached.fetchedAt = cached.fetchedAt.Add(-discoveryMaxAge)
+		discoveryCache[server.URL] = cached
+	}
+
+	authorize.Store("/v2/authorize")
+	if redirect := p.GetRedirectURL(auth.AuthorizationParams{}); !strings.HasPrefix(redirect, server.URL+"/authorize?") {
+		t.Errorf("Expected the cached authorization endpoint, got %s", redirect)
+	}
+
+	age()
+	if redirect := p.GetRedirectURL(auth.AuthorizationParams{}); !strings.HasPrefix(redirect, server.URL+"/v2/authorize?") {
+		t.Errorf("Expected the authorization endpoint of the refetched document, got %s", redirect)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("Expected a stale discovery document to be refetched once, got %d requests", requests.Load())
+	}
+
+	failing.Store(true)
+	age()
+	if redirect := p.GetRedirectURL(auth.AuthorizationParams{}); !strings.HasPrefix(redirect, server.URL+"/v2/authorize?") {
+		t.Errorf("Expected the known document while the issuer is unavailable, got %s", redirect)
+	}
+}
+
+func TestOIDCIssuerMismatch(t *testing.T) {
+	server, _ := newDiscoveryServer(t, func(string) string { return "https://evil.example.com" })
+
+	if _, err := OIDC(server.URL, "client", "secret", OIDCOptions{}); err == nil {
+		t.Errorf("Expected discovery document for another issuer to be rejected")
+	}
+}
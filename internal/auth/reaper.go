@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// reaper periodically deletes expired sessions from the adapter.
+type reaper struct {
+	adapter  Adapter
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+func startReaper(adapter Adapter, interval time.Duration) *reaper {
+	r := &reaper{
+		adapter:  adapter,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+func (r *reaper) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case now := <-ticker.C:
+			if _, err := r.adapter.DeleteExpiredSessions(now); err != nil {
+				log.Printf("auth: could not purge expired sessions: %v", err)
+			}
+		}
+	}
+}
+
+// Stop ends the reaper and waits for an in-flight purge to finish.
+func (r *reaper) Stop() {
+	r.once.Do(func() {
+		close(r.stop)
+	})
+	<-r.done
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// purgeAdapter stores sessions for the reaper. The methods it does not
+// override are not used by the reaper.
+type purgeAdapter struct {
+	Adapter
+	mu       sync.Mutex
+	sessions []Session
+	purges   int
+}
+
+func (a *purgeAdapter) DeleteExpiredSessions(now time.Time) (int64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.purges++
+	var kept []Session
+	for _, session := range a.sessions {
+		if session.Expires.After(now) {
+			kept = append(kept, session)
+		}
+	}
+	deleted := int64(len(a.sessions) - len(kept))
+	a.sessions = kept
+	return deleted, nil
+}
+
+func (a *purgeAdapter) state() ([]Session, int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.sessions, a.purges
+}
+
+func TestReaper(t *testing.T) {
+	now := time.Now()
+	adapter := &purgeAdapter{sessions: []Session{
+		{SessionToken: "expired", Expires: now.Add(-time.Minute)},
+		{SessionToken: "valid", Expires: now.Add(time.Hour)},
+	}}
+	s := New(AuthServiceOptions{
+		Adapter: adapter,
+		Session: SessionOptions{PurgeInterval: 10 * time.Millisecond},
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sessions, _ := adapter.state()
+		if len(sessions) == 1 {
+			if sessions[0].SessionToken != "valid" {
+				t.Fatalf("expected the valid session to be kept, got %+v", sessions)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the expired session to be purged, got %+v", sessions)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected Close to stop the reaper")
+	}
+
+	_, purges := adapter.state()
+	time.Sleep(50 * time.Millisecond)
+	if _, after := adapter.state(); after != purges {
+		t.Errorf("expected no purge after Close, got %d more", after-purges)
+	}
+}
+
+func TestReaperDisabled(t *testing.T) {
+	s := New(AuthServiceOptions{
+		Adapter: &purgeAdapter{},
+		Session: SessionOptions{PurgeInterval: -1},
+	})
+	if s.reaper != nil {
+		t.Errorf("expected no reaper with a negative PurgeInterval")
+	}
+	s.Close()
+}
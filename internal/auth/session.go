@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type SessionStrategy string
+
+const (
+	// SessionStrategyDatabase stores sessions through the adapter and keeps
+	// an opaque session token in the cookie.
+	SessionStrategyDatabase SessionStrategy = "database"
+	// SessionStrategyJWT keeps the whole session in an encrypted token in the
+	// cookie and never touches the adapter's session storage.
+	SessionStrategyJWT SessionStrategy = "jwt"
+)
+
+type SessionOptions struct {
+	// Strategy selects where sessions are kept. Defaults to SessionStrategyDatabase.
+	Strategy SessionStrategy
+	// JWT configures the jwt strategy.
+	JWT JWTOptions
+	// MaxAge is how long a session stays valid after its last renewal.
+	MaxAge time.Duration
+	// UpdateAge is how often an active session is renewed. Requests made
+	// within UpdateAge of the last renewal leave the session untouched.
+	UpdateAge time.Duration
+	// AbsoluteMaxAge caps the lifetime of a session regardless of activity.
+	// Zero means sessions can be renewed indefinitely.
+	AbsoluteMaxAge time.Duration
+	// PurgeInterval is how often expired sessions are deleted in the
+	// background. A negative value disables the purge.
+	PurgeInterval time.Duration
+}
+
+func (o SessionOptions) withDefaults() SessionOptions {
+	if o.Strategy == "" {
+		o.Strategy = SessionStrategyDatabase
+	}
+	if o.JWT.Secret == "" {
+		o.JWT.Secret = AUTH_SECRET
+	}
+	if o.MaxAge <= 0 {
+		o.MaxAge = 30 * 24 * time.Hour
+	}
+	if o.UpdateAge <= 0 {
+		o.UpdateAge = 24 * time.Hour
+	}
+	if o.PurgeInterval == 0 {
+		o.PurgeInterval = time.Hour
+	}
+	return o
+}
+
+// expiresAt returns the expiry of a session created at createdAt and renewed at now.
+func (o SessionOptions) expiresAt(createdAt, now time.Time) time.Time {
+	expires := now.Add(o.MaxAge)
+	if o.AbsoluteMaxAge > 0 {
+		if limit := createdAt.Add(o.AbsoluteMaxAge); expires.After(limit) {
+			expires = limit
+		}
+	}
+	return expires
+}
+
+// needsRenewal reports whether the session was last renewed more than UpdateAge ago.
+func (o SessionOptions) needsRenewal(session Session, now time.Time) bool {
+	lastRenewed := session.Expires.Add(-o.MaxAge)
+	return now.Sub(lastRenewed) >= o.UpdateAge
+}
+
+// createSession starts a session for the user of params and sets the session cookie.
+func (s *Service) createSession(c echo.Context, params SignInParams) (Session, error) {
+	session, err := s.issueSession(c, params)
+	if err != nil {
+		return Session{}, err
+	}
+
+	setSessionCookie(c, session)
+	return session, nil
+}
+
+// issueSession starts a session for the user of params. The caller hands
+// the session token to the client.
+func (s *Service) issueSession(c echo.Context, params SignInParams) (Session, error) {
+	user := params.User
+	now := time.Now()
+	expires := s.session.expiresAt(now, now)
+
+	var session Session
+	switch s.session.Strategy {
+	case SessionStrategyJWT:
+		claims := sessionClaims(user, now, expires)
+		if s.callbacks.JWT != nil {
+			var err error
+			claims, err = s.callbacks.JWT(JWTParams{
+				Token:   claims,
+				User:    user,
+				Account: params.Account,
+				Profile: params.Profile,
+			})
+			if err != nil {
+				return Session{}, err
+			}
+		}
+
+		token, err := s.session.JWT.encodeJWT(claims)
+		if err != nil {
+			return Session{}, err
+		}
+		session = Session{
+			SessionToken: token,
+			UserId:       user.Id,
+			Expires:      expires,
+			CreatedAt:    now,
+			claims:       claims,
+		}
+	default:
+		var err error
+		session, err = (*s.adapter).CreateSession(user, expires)
+		if err != nil {
+			return Session{}, err
+		}
+		if session.SessionToken == "" {
+			return Session{}, fmt.Errorf("adapter returned an empty session token")
+		}
+	}
+
+	s.publish(c, Event{Type: EventCreateSession, User: user, Provider: params.Account.Provider})
+	return session, nil
+}
+
+// getSession resolves the session token of the request and renews the
+// session when it is past the update threshold. Only the session cookie is
+// reissued, clients sending a bearer token get no cookie.
+func (s *Service) getSession(c echo.Context) (Session, User, error) {
+	token, fromCookie := getSessionToken(c)
+	if token == "" {
+		return Session{}, User{}, fmt.Errorf("missing session")
+	}
+
+	now := time.Now()
+
+	if s.session.Strategy == SessionStrategyJWT {
+		claims, err := s.session.JWT.decodeJWT(token)
+		if err != nil {
+			return Session{}, User{}, err
+		}
+		session, user := sessionFromClaims(token, claims)
+
+		// A renewed token could not reach a bearer client, which keeps using
+		// the one it has until it expires.
+		if fromCookie && s.session.needsRenewal(session, now) {
+			expires := s.session.expiresAt(session.CreatedAt, now)
+			if expires.After(session.Expires) {
+				claims["iat"] = now.Unix()
+				claims["exp"] = expires.Unix()
+				if renewed, err := s.session.JWT.encodeJWT(claims); err != nil {
+					log.Printf("auth: could not renew session: %v", err)
+				} else {
+					session.SessionToken = renewed
+					session.Expires = time.Unix(expires.Unix(), 0)
+					setSessionCookie(c, session)
+				}
+			}
+		}
+
+		return session, user, nil
+	}
+
+	session, user, err := (*s.adapter).GetSessionAndUser(token)
+	if err != nil {
+		return Session{}, User{}, err
+	}
+
+	if s.session.needsRenewal(session, now) {
+		expires := s.session.expiresAt(session.CreatedAt, now)
+		if expires.After(session.Expires) {
+			if renewed, err := (*s.adapter).UpdateSession(session.SessionToken, expires); err != nil {
+				log.Printf("auth: could not renew session: %v", err)
+			} else {
+				session = renewed
+				if fromCookie {
+					setSessionCookie(c, session)
+				}
+			}
+		}
+	}
+
+	return session, user, nil
+}
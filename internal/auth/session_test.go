@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestSessionRenewal(t *testing.T) {
+	opts := SessionOptions{MaxAge: time.Hour, UpdateAge: 10 * time.Minute}.withDefaults()
+	now := time.Now()
+
+	fresh := Session{Expires: now.Add(55 * time.Minute)}
+	if opts.needsRenewal(fresh, now) {
+		t.Errorf("Expected session renewed 5 minutes ago to be left untouched")
+	}
+
+	stale := Session{Expires: now.Add(45 * time.Minute)}
+	if !opts.needsRenewal(stale, now) {
+		t.Errorf("Expected session renewed 15 minutes ago to be renewed")
+	}
+}
+
+func TestSessionAbsoluteMaxAge(t *testing.T) {
+	opts := SessionOptions{MaxAge: time.Hour, AbsoluteMaxAge: 2 * time.Hour}.withDefaults()
+	now := time.Now()
+
+	if got := opts.expiresAt(now, now); !got.Equal(now.Add(time.Hour)) {
+		t.Errorf("Expected new session to expire after MaxAge, got %v", got)
+	}
+
+	createdAt := now.Add(-90 * time.Minute)
+	if got := opts.expiresAt(createdAt, now); !got.Equal(createdAt.Add(2 * time.Hour)) {
+		t.Errorf("Expected renewal to be capped by AbsoluteMaxAge, got %v", got)
+	}
+}
+
+func TestSessionRenewalOnlySetsCookieForCookies(t *testing.T) {
+	s := newJWTTestService(t)
+	e := echo.New()
+	now := time.Now()
+	token, err := s.session.JWT.encodeJWT(sessionClaims(User{Id: "user-1"}, now.Add(-48*time.Hour), now.Add(time.Hour)))
+	if err != nil {
+		t.Fatalf("encodeJWT() error = %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+	rec := httptest.NewRecorder()
+	if _, _, err := s.getSession(e.NewContext(req, rec)); err != nil {
+		t.Fatalf("getSession() error = %v", err)
+	}
+	if len(rec.Result().Cookies()) != 1 {
+		t.Errorf("Expected the session cookie to be renewed")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	rec = httptest.NewRecorder()
+	if _, _, err := s.getSession(e.NewContext(req, rec)); err != nil {
+		t.Fatalf("getSession() error = %v", err)
+	}
+	if cookies := rec.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("Expected no cookie for a bearer token, got %v", cookies)
+	}
+}
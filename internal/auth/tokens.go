@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// tokenRefreshSkew renews access tokens slightly before they expire, so
+// they are still valid by the time the caller uses them.
+const tokenRefreshSkew = time.Minute
+
+var (
+	// ErrAccountNotFound is returned when the user has no account with the provider.
+	ErrAccountNotFound = errors.New("account not found")
+	// ErrTokenExpired is returned when the access token has expired and
+	// cannot be refreshed. The user has to sign in with the provider again.
+	ErrTokenExpired = errors.New("access token expired")
+)
+
+// GetProviderToken returns a valid access token of the user for a provider,
+// refreshing the stored one first when it has expired.
+func (s *Service) GetProviderToken(ctx context.Context, userId, providerId string) (string, error) {
+	provider, ok := (*s.providers)[providerId]
+	if !ok {
+		return "", fmt.Errorf("provider not found")
+	}
+
+	account, err := s.getAccount(userId, providerId)
+	if err != nil {
+		return "", err
+	}
+	if !tokenExpired(account, time.Now()) {
+		return account.AccessToken, nil
+	}
+
+	// Refresh tokens are often single use, so only one caller may refresh
+	// an account at a time. The others wait and reuse its result.
+	unlock := s.refreshLocks.lock(account.Id)
+	defer unlock()
+
+	account, err = s.getAccount(userId, providerId)
+	if err != nil {
+		return "", err
+	}
+	if !tokenExpired(account, time.Now()) {
+		return account.AccessToken, nil
+	}
+
+	refresher, ok := provider.(TokenRefresher)
+	if !ok || account.RefreshToken == nil || *account.RefreshToken == "" {
+		return "", ErrTokenExpired
+	}
+
+	tokenSet, err := refresher.Refresh(ctx, *account.RefreshToken)
+	if err != nil {
+		return "", fmt.Errorf("refreshing access token: %w", err)
+	}
+
+	account.AccessToken = tokenSet.AccessToken
+	account.ExpiresAt = int64(tokenSet.ExpiresAt)
+	if tokenSet.RefreshToken != "" {
+		account.RefreshToken = &tokenSet.RefreshToken
+	}
+	if tokenSet.IdToken != "" {
+		account.IdToken = tokenSet.IdToken
+	}
+	if tokenSet.Scope != "" {
+		account.Scope = tokenSet.Scope
+	}
+	if tokenSet.TokenType != "" {
+		account.TokenType = tokenSet.TokenType
+	}
+
+	if _, err := (*s.adapter).UpdateAccount(account); err != nil {
+		return "", err
+	}
+	return account.AccessToken, nil
+}
+
+// storeTokens saves the tokens of a returning sign in on the stored
+// account, which may have been wiped by revoking them at sign out. The
+// stored refresh token is kept when the provider issues none, as many only
+// do on the first sign in.
+func (s *Service) storeTokens(userId string, signedIn Account) error {
+	account, err := s.getAccount(userId, signedIn.Provider)
+	if err != nil || account.ProviderAccountId != signedIn.ProviderAccountId {
+		return err
+	}
+
+	unlock := s.refreshLocks.lock(account.Id)
+	defer unlock()
+
+	account.AccessToken = signedIn.AccessToken
+	account.ExpiresAt = signedIn.ExpiresAt
+	if signedIn.RefreshToken != nil && *signedIn.RefreshToken != "" {
+		account.RefreshToken = signedIn.RefreshToken
+	}
+	account.IdToken = signedIn.IdToken
+	account.Scope = signedIn.Scope
+	account.TokenType = signedIn.TokenType
+
+	_, err = (*s.adapter).UpdateAccount(account)
+	return err
+}
+
+// getAccount returns the account of the user with a provider.
+func (s *Service) getAccount(userId, providerId string) (Account, error) {
+	accounts, err := (*s.adapter).GetAccounts(userId)
+	if err != nil {
+		return Account{}, err
+	}
+
+	for _, account := range accounts {
+		if account.Provider == providerId {
+			return account, nil
+		}
+	}
+	return Account{}, ErrAccountNotFound
+}
+
+// tokenExpired reports whether the access token of account must be
+// renewed. Tokens without a known expiry are assumed valid.
+func tokenExpired(account Account, now time.Time) bool {
+	if account.AccessToken == "" {
+		return true
+	}
+	if account.ExpiresAt == 0 {
+		return false
+	}
+	return now.Add(tokenRefreshSkew).Unix() >= account.ExpiresAt
+}
+
+// revokeUserTokens revokes the provider tokens of every account of the
+// user and wipes them from the adapter.
+func (s *Service) revokeUserTokens(ctx context.Context, userId string) error {
+	if *s.adapter == nil {
+		return nil
+	}
+
+	accounts, err := (*s.adapter).GetAccounts(userId)
+	if err != nil {
+		return err
+	}
+
+	for _, account := range accounts {
+		if account.AccessToken == "" && (account.RefreshToken == nil || *account.RefreshToken == "") {
+			continue
+		}
+
+		s.revokeTokens(ctx, account)
+
+		account.AccessToken = ""
+		account.RefreshToken = nil
+		account.ExpiresAt = 0
+		account.IdToken = ""
+		if _, err := (*s.adapter).UpdateAccount(account); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revokeTokens revokes the tokens of account at its provider. Revocation is
+// best effort: the user is leaving either way, so failures are only logged.
+func (s *Service) revokeTokens(ctx context.Context, account Account) {
+	revoker, ok := (*s.providers)[account.Provider].(TokenRevoker)
+	if !ok {
+		return
+	}
+
+	// Revoking the refresh token also invalidates the access tokens issued
+	// with it at most providers, the access token is revoked for the others.
+	if account.RefreshToken != nil && *account.RefreshToken != "" {
+		if err := revoker.Revoke(ctx, *account.RefreshToken, "refresh_token"); err != nil {
+			log.Printf("auth: could not revoke %s refresh token: %v", account.Provider, err)
+		}
+	}
+	if account.AccessToken != "" {
+		if err := revoker.Revoke(ctx, account.AccessToken, "access_token"); err != nil {
+			log.Printf("auth: could not revoke %s access token: %v", account.Provider, err)
+		}
+	}
+}
+
+// keyedMutex serializes work per key and forgets keys nobody holds.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	waiters int
+}
+
+func (k *keyedMutex) lock(key string) (unlock func()) {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyedLock)
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.waiters++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		k.mu.Lock()
+		l.waiters--
+		if l.waiters == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tokenAdapter stores a single account. The methods it does not override
+// are not used by GetProviderToken.
+type tokenAdapter struct {
+	Adapter
+	mu      sync.Mutex
+	account Account
+}
+
+func (a *tokenAdapter) GetAccounts(userId string) ([]Account, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return []Account{a.account}, nil
+}
+
+func (a *tokenAdapter) UpdateAccount(account Account) (Account, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.account = account
+	return account, nil
+}
+
+type refreshingProvider struct {
+	Provider
+	refreshes atomic.Int32
+	revoked   []string
+}
+
+func (p *refreshingProvider) GetId() string { return "test" }
+
+func (p *refreshingProvider) Refresh(ctx context.Context, refreshToken string) (TokenSet, error) {
+	p.refreshes.Add(1)
+	time.Sleep(10 * time.Millisecond)
+	return TokenSet{AccessToken: "renewed", RefreshToken: "rotated", ExpiresAt: int(time.Now().Add(time.Hour).Unix())}, nil
+}
+
+func (p *refreshingProvider) Revoke(ctx context.Context, token, tokenTypeHint string) error {
+	p.revoked = append(p.revoked, tokenTypeHint+":"+token)
+	return nil
+}
+
+func TestGetProviderToken(t *testing.T) {
+	refreshToken := "refresh"
+	adapter := &tokenAdapter{account: Account{
+		Id:           "account",
+		Provider:     "test",
+		AccessToken:  "expired",
+		RefreshToken: &refreshToken,
+		ExpiresAt:    time.Now().Add(-time.Minute).Unix(),
+	}}
+	provider := &refreshingProvider{}
+	s := New(AuthServiceOptions{
+		Providers: []Provider{provider},
+		Adapter:   adapter,
+		Session:   SessionOptions{PurgeInterval: -1},
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := s.GetProviderToken(context.Background(), "user", "test")
+			if err != nil || token != "renewed" {
+				t.Errorf("GetProviderToken() = %q, %v", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := provider.refreshes.Load(); n != 1 {
+		t.Errorf("expected a single refresh, got %d", n)
+	}
+	if *adapter.account.RefreshToken != "rotated" {
+		t.Errorf("expected the rotated refresh token to be stored, got %s", *adapter.account.RefreshToken)
+	}
+
+	if _, err := s.GetProviderToken(context.Background(), "user", "missing"); err == nil {
+		t.Errorf("expected an error for an unknown provider")
+	}
+}
+
+func TestGetProviderTokenWithoutRefreshToken(t *testing.T) {
+	adapter := &tokenAdapter{account: Account{
+		Id:          "account",
+		Provider:    "test",
+		AccessToken: "expired",
+		ExpiresAt:   time.Now().Add(-time.Minute).Unix(),
+	}}
+	s := New(AuthServiceOptions{
+		Providers: []Provider{&refreshingProvider{}},
+		Adapter:   adapter,
+		Session:   SessionOptions{PurgeInterval: -1},
+	})
+
+	if _, err := s.GetProviderToken(context.Background(), "user", "test"); err != ErrTokenExpired {
+		t.Errorf("expected ErrTokenExpired, got %v", err)
+	}
+}
+
+func TestRevokeUserTokens(t *testing.T) {
+	refreshToken := "refresh"
+	adapter := &tokenAdapter{account: Account{
+		Id:           "account",
+		Provider:     "test",
+		AccessToken:  "access",
+		RefreshToken: &refreshToken,
+		ExpiresAt:    time.Now().Add(time.Hour).Unix(),
+	}}
+	provider := &refreshingProvider{}
+	s := New(AuthServiceOptions{
+		Providers: []Provider{provider},
+		Adapter:   adapter,
+		Session:   SessionOptions{PurgeInterval: -1},
+	})
+
+	if err := s.revokeUserTokens(context.Background(), "user"); err != nil {
+		t.Fatalf("revokeUserTokens() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(provider.revoked, []string{"refresh_token:refresh", "access_token:access"}) {
+		t.Errorf("expected both tokens to be revoked, got %v", provider.revoked)
+	}
+	if adapter.account.AccessToken != "" || adapter.account.RefreshToken != nil {
+		t.Errorf("expected the stored tokens to be wiped, got %+v", adapter.account)
+	}
+	if _, err := s.GetProviderToken(context.Background(), "user", "test"); err != ErrTokenExpired {
+		t.Errorf("expected ErrTokenExpired after revocation, got %v", err)
+	}
+}
@@ -7,23 +7,41 @@ import (
 	"encoding/hex"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var AUTH_SECRET = os.Getenv("AUTH_SECRET")
 
-func GenerateHMACToken(size int) string {
-	randomStr := generateRandomString(size)
-	hash := createHMACHash(randomStr)
-	return fmt.Sprintf("%s|%s", randomStr, hash)
+// GenerateState returns an OAuth state that records when it was issued, so
+// VerifyState can reject it once it is older than the allowed age.
+func GenerateState() string {
+	payload := fmt.Sprintf("%s|%d", generateRandomString(32), time.Now().Unix())
+	return fmt.Sprintf("%s|%s", payload, createHMACHash(payload))
 }
 
-func VerifyHMACToken(token string) bool {
-	parts := strings.Split(token, "|")
-	if len(parts) != 2 {
-		return false
+// VerifyState checks that state was minted by GenerateState within maxAge.
+func VerifyState(state string, maxAge time.Duration) error {
+	parts := strings.Split(state, "|")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed state")
 	}
-	return createHMACHash(parts[0]) == parts[1]
+
+	payload := parts[0] + "|" + parts[1]
+	if !hmac.Equal([]byte(createHMACHash(payload)), []byte(parts[2])) {
+		return fmt.Errorf("invalid state")
+	}
+
+	issuedAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed state")
+	}
+	if time.Since(time.Unix(issuedAt, 0)) > maxAge {
+		return fmt.Errorf("expired state")
+	}
+
+	return nil
 }
 
 func createHMACHash(input string) string {
@@ -1,23 +1,24 @@
 package auth
 
-import "testing"
+import (
+	"strings"
+	"testing"
+	"time"
+)
 
-func TestOAuthVerificationKey(t *testing.T) {
-	// Test with a valid generated token
-	validToken := GenerateHMACToken(16)
-	if !VerifyHMACToken(validToken) {
-		t.Errorf("Expected valid token to pass verification")
+func TestState(t *testing.T) {
+	state := GenerateState()
+	if err := VerifyState(state, time.Minute); err != nil {
+		t.Errorf("Expected fresh state to pass verification, got %v", err)
 	}
 
-	// Test with an invalid token
-	invalidToken := "invalid"
-	if VerifyHMACToken(invalidToken) {
-		t.Errorf("Expected invalid token to fail verification")
+	parts := strings.Split(state, "|")
+	old := parts[0] + "|" + "1"
+	if err := VerifyState(old+"|"+createHMACHash(old), time.Minute); err == nil {
+		t.Errorf("Expected expired state to fail verification")
 	}
 
-	// Test with a malformed token
-	malformedToken := "malformed|token|extra"
-	if VerifyHMACToken(malformedToken) {
-		t.Errorf("Expected malformed token to fail verification")
+	if err := VerifyState(parts[0]+"|"+parts[1]+"|forged", time.Minute); err == nil {
+		t.Errorf("Expected forged state to fail verification")
 	}
 }
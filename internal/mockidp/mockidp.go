@@ -0,0 +1,481 @@
+// Package mockidp is an OpenID Connect provider for local development and
+// tests. It signs in as one of a fixed set of test users picked on a web
+// page, without credentials or network access.
+//
+// Point a provider at it with providers.OIDC(server.Issuer(), clientId,
+// clientSecret, providers.OIDCOptions{Id: "mock"}).
+package mockidp
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	codeMaxAge      = time.Minute
+	defaultTokenTTL = time.Hour
+)
+
+// User is a test user. Claims are returned in the id_token and by the
+// UserInfo endpoint, e.g. name, email, email_verified and picture.
+type User struct {
+	Sub    string                 `json:"sub"`
+	Claims map[string]interface{} `json:"claims"`
+}
+
+// DefaultUsers are used when Options.Users is empty. Bob's email is not
+// verified, to exercise the account linking rules.
+var DefaultUsers = []User{
+	{Sub: "alice", Claims: map[string]interface{}{
+		"name":           "Alice Example",
+		"given_name":     "Alice",
+		"family_name":    "Example",
+		"email":          "alice@example.com",
+		"email_verified": true,
+	}},
+	{Sub: "bob", Claims: map[string]interface{}{
+		"name":           "Bob Example",
+		"given_name":     "Bob",
+		"family_name":    "Example",
+		"email":          "bob@example.com",
+		"email_verified": false,
+	}},
+}
+
+type Options struct {
+	// Issuer is the URL the server is reachable at, e.g. "http://localhost:9000".
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	// Users to pick from. Defaults to DefaultUsers.
+	Users []User
+	// TokenTTL is the lifetime of access and id tokens. Defaults to an hour.
+	TokenTTL time.Duration
+}
+
+// authorization is a code issued to a client, waiting to be exchanged.
+type authorization struct {
+	user          User
+	redirectURI   string
+	scope         string
+	nonce         string
+	codeChallenge string
+	expires       time.Time
+}
+
+type grant struct {
+	user    User
+	scope   string
+	expires time.Time
+}
+
+// Server is the mock provider. It implements http.Handler.
+type Server struct {
+	opts Options
+	key  *rsa.PrivateKey
+	kid  string
+	mux  *http.ServeMux
+
+	mu            sync.Mutex
+	codes         map[string]authorization
+	accessTokens  map[string]grant
+	refreshTokens map[string]grant
+}
+
+// New returns a provider with a freshly generated signing key.
+func New(opts Options) (*Server, error) {
+	if opts.Issuer == "" || opts.ClientId == "" {
+		return nil, fmt.Errorf("mockidp: Issuer and ClientId are required")
+	}
+	opts.Issuer = strings.TrimSuffix(opts.Issuer, "/")
+	if len(opts.Users) == 0 {
+		opts.Users = DefaultUsers
+	}
+	if opts.TokenTTL == 0 {
+		opts.TokenTTL = defaultTokenTTL
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		opts:          opts,
+		key:           key,
+		kid:           randomString(8),
+		mux:           http.NewServeMux(),
+		codes:         map[string]authorization{},
+		accessTokens:  map[string]grant{},
+		refreshTokens: map[string]grant{},
+	}
+
+	s.mux.HandleFunc("GET /.well-known/openid-configuration", s.discovery)
+	s.mux.HandleFunc("GET /authorize", s.authorize)
+	s.mux.HandleFunc("POST /authorize", s.authorize)
+	s.mux.HandleFunc("POST /token", s.token)
+	s.mux.HandleFunc("GET /userinfo", s.userInfo)
+	s.mux.HandleFunc("GET /jwks", s.jwks)
+	s.mux.HandleFunc("POST /revoke", s.revoke)
+
+	return s, nil
+}
+
+// Issuer returns the issuer of the tokens, which is also the base URL of
+// the discovery document.
+func (s *Server) Issuer() string {
+	return s.opts.Issuer
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.opts.Issuer,
+		"authorization_endpoint":                s.opts.Issuer + "/authorize",
+		"token_endpoint":                        s.opts.Issuer + "/token",
+		"userinfo_endpoint":                     s.opts.Issuer + "/userinfo",
+		"jwks_uri":                              s.opts.Issuer + "/jwks",
+		"revocation_endpoint":                   s.opts.Issuer + "/revoke",
+		"scopes_supported":                      []string{"openid", "profile", "email"},
+		"response_types_supported":              []string{"code"},
+		"response_modes_supported":              []string{"query", "form_post"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+var pickerPage = template.Must(template.New("picker").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Mock identity provider</title></head>
+<body>
+<h1>Sign in as</h1>
+<form method="post">
+{{range $key, $value := .Query}}<input type="hidden" name="{{$key}}" value="{{index $value 0}}">
+{{end}}{{range .Users}}<p><button name="user" value="{{.Sub}}">{{.Label}}</button></p>
+{{end}}<p><button name="deny" value="1">Cancel</button></p>
+</form>
+</body>
+</html>
+`))
+
+var formPostPage = template.Must(template.New("form_post").Parse(`<!DOCTYPE html>
+<html>
+<body onload="document.forms[0].submit()">
+<form method="post" action="{{.Action}}">
+{{range $key, $value := .Params}}<input type="hidden" name="{{$key}}" value="{{index $value 0}}">
+{{end}}<noscript><button>Continue</button></noscript>
+</form>
+</body>
+</html>
+`))
+
+// authorize shows the user picker on GET and issues a code for the picked
+// user on POST.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	if r.Form.Get("client_id") != s.opts.ClientId {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	redirectURI := r.Form.Get("redirect_uri")
+	if u, err := url.Parse(redirectURI); err != nil || !u.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	params := url.Values{}
+	if state := r.Form.Get("state"); state != "" {
+		params.Set("state", state)
+	}
+	params.Set("iss", s.opts.Issuer)
+
+	if r.Form.Get("response_type") != "code" {
+		params.Set("error", "unsupported_response_type")
+		s.redirect(w, r, redirectURI, params)
+		return
+	}
+	if method := r.Form.Get("code_challenge_method"); r.Form.Get("code_challenge") != "" && method != "S256" {
+		params.Set("error", "invalid_request")
+		params.Set("error_description", "only the S256 code challenge method is supported")
+		s.redirect(w, r, redirectURI, params)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		s.renderPicker(w, r.Form)
+		return
+	}
+
+	if r.Form.Get("deny") != "" {
+		params.Set("error", "access_denied")
+		params.Set("error_description", "The user cancelled the sign in.")
+		s.redirect(w, r, redirectURI, params)
+		return
+	}
+
+	user, ok := s.findUser(r.Form.Get("user"))
+	if !ok {
+		http.Error(w, "unknown user", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString(32)
+	s.mu.Lock()
+	s.codes[code] = authorization{
+		user:          user,
+		redirectURI:   redirectURI,
+		scope:         r.Form.Get("scope"),
+		nonce:         r.Form.Get("nonce"),
+		codeChallenge: r.Form.Get("code_challenge"),
+		expires:       time.Now().Add(codeMaxAge),
+	}
+	s.mu.Unlock()
+
+	params.Set("code", code)
+	s.redirect(w, r, redirectURI, params)
+}
+
+func (s *Server) renderPicker(w http.ResponseWriter, query url.Values) {
+	type option struct{ Sub, Label string }
+	users := make([]option, 0, len(s.opts.Users))
+	for _, user := range s.opts.Users {
+		label := user.Sub
+		if name, _ := user.Claims["name"].(string); name != "" {
+			label = name
+		}
+		if email, _ := user.Claims["email"].(string); email != "" {
+			label += " <" + email + ">"
+		}
+		users = append(users, option{Sub: user.Sub, Label: label})
+	}
+
+	// The picked user and the cancel button are the only fields the form adds.
+	query.Del("user")
+	query.Del("deny")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	pickerPage.Execute(w, map[string]interface{}{"Query": query, "Users": users})
+}
+
+// redirect returns to the client with params, in the query or as a form
+// post when the client asked for response_mode=form_post.
+func (s *Server) redirect(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	if r.Form.Get("response_mode") == "form_post" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		formPostPage.Execute(w, map[string]interface{}{"Action": redirectURI, "Params": params})
+		return
+	}
+
+	u, _ := url.Parse(redirectURI)
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	u.RawQuery = query.Encode()
+	http.Redirect(w, r, u.String(), http.StatusSeeOther)
+}
+
+// token implements the authorization_code and refresh_token grants.
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	if !s.authenticateClient(r) {
+		tokenError(w, http.StatusUnauthorized, "invalid_client", "")
+		return
+	}
+
+	switch r.Form.Get("grant_type") {
+	case "authorization_code":
+		s.mu.Lock()
+		auth, ok := s.codes[r.Form.Get("code")]
+		delete(s.codes, r.Form.Get("code"))
+		s.mu.Unlock()
+
+		switch {
+		case !ok || time.Now().After(auth.expires):
+			tokenError(w, http.StatusBadRequest, "invalid_grant", "unknown or expired code")
+		case r.Form.Get("redirect_uri") != auth.redirectURI:
+			tokenError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri does not match the authorization request")
+		case auth.codeChallenge != "" && codeChallengeS256(r.Form.Get("code_verifier")) != auth.codeChallenge:
+			tokenError(w, http.StatusBadRequest, "invalid_grant", "code_verifier does not match the code challenge")
+		default:
+			s.issueTokens(w, auth.user, auth.scope, auth.nonce)
+		}
+	case "refresh_token":
+		s.mu.Lock()
+		refresh, ok := s.refreshTokens[r.Form.Get("refresh_token")]
+		// Refresh tokens are rotated on every use.
+		delete(s.refreshTokens, r.Form.Get("refresh_token"))
+		s.mu.Unlock()
+
+		if !ok {
+			tokenError(w, http.StatusBadRequest, "invalid_grant", "unknown refresh token")
+			return
+		}
+		s.issueTokens(w, refresh.user, refresh.scope, "")
+	default:
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+	}
+}
+
+// authenticateClient accepts client_secret_post and client_secret_basic.
+func (s *Server) authenticateClient(r *http.Request) bool {
+	clientId, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientId, clientSecret = r.Form.Get("client_id"), r.Form.Get("client_secret")
+	}
+
+	return clientId == s.opts.ClientId &&
+		subtle.ConstantTimeCompare([]byte(clientSecret), []byte(s.opts.ClientSecret)) == 1
+}
+
+func (s *Server) issueTokens(w http.ResponseWriter, user User, scope, nonce string) {
+	now := time.Now()
+	claims := jwt.MapClaims{}
+	for key, value := range user.Claims {
+		claims[key] = value
+	}
+	claims["iss"] = s.opts.Issuer
+	claims["sub"] = user.Sub
+	claims["aud"] = s.opts.ClientId
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(s.opts.TokenTTL).Unix()
+	claims["auth_time"] = now.Unix()
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.kid
+	idToken, err := token.SignedString(s.key)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	accessToken, refreshToken := randomString(32), randomString(32)
+	s.mu.Lock()
+	s.accessTokens[accessToken] = grant{user: user, scope: scope, expires: now.Add(s.opts.TokenTTL)}
+	s.refreshTokens[refreshToken] = grant{user: user, scope: scope}
+	s.mu.Unlock()
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(s.opts.TokenTTL.Seconds()),
+		"refresh_token": refreshToken,
+		"id_token":      idToken,
+		"scope":         scope,
+	})
+}
+
+func (s *Server) userInfo(w http.ResponseWriter, r *http.Request) {
+	accessToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	s.mu.Lock()
+	access, found := s.accessTokens[accessToken]
+	s.mu.Unlock()
+
+	if !ok || !found || time.Now().After(access.expires) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	claims := map[string]interface{}{}
+	for key, value := range access.user.Claims {
+		claims[key] = value
+	}
+	claims["sub"] = access.user.Sub
+	writeJSON(w, http.StatusOK, claims)
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	encode := func(b []byte) string {
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": s.kid,
+			"n":   encode(s.key.N.Bytes()),
+			"e":   encode(big.NewInt(int64(s.key.E)).Bytes()),
+		}},
+	})
+}
+
+// revoke implements RFC 7009. Unknown tokens are not an error.
+func (s *Server) revoke(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	if !s.authenticateClient(r) {
+		tokenError(w, http.StatusUnauthorized, "invalid_client", "")
+		return
+	}
+
+	token := r.Form.Get("token")
+	s.mu.Lock()
+	delete(s.accessTokens, token)
+	delete(s.refreshTokens, token)
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) findUser(sub string) (User, bool) {
+	for _, user := range s.opts.Users {
+		if user.Sub == sub {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+func tokenError(w http.ResponseWriter, status int, code, description string) {
+	body := map[string]string{"error": code}
+	if description != "" {
+		body["error_description"] = description
+	}
+	writeJSON(w, status, body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func codeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
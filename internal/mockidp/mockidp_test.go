@@ -0,0 +1,179 @@
+package mockidp
+
+import (
+	"echo-server/internal/auth"
+	"echo-server/internal/auth/adapters"
+	"echo-server/internal/auth/providers"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// newTestIdP starts a provider over TLS, as the auth cookies are Secure.
+func newTestIdP(t *testing.T) (*Server, *httptest.Server) {
+	server := httptest.NewUnstartedServer(nil)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	idp, err := New(Options{Issuer: server.URL, ClientId: "client", ClientSecret: "secret"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	server.Config.Handler = idp
+	return idp, server
+}
+
+// newTestApp starts an app signing in with the provider.
+func newTestApp(t *testing.T, idp *httptest.Server) (*httptest.Server, *http.Client) {
+	provider, err := providers.OIDC(idp.URL, "client", "secret", providers.OIDCOptions{
+		Id:         "mock",
+		HTTPClient: idp.Client(),
+	})
+	if err != nil {
+		t.Fatalf("OIDC() error = %v", err)
+	}
+
+	service := auth.New(auth.AuthServiceOptions{
+		Providers: []auth.Provider{provider},
+		Adapter:   adapters.Memory(),
+		Session:   auth.SessionOptions{PurgeInterval: -1},
+	})
+	t.Cleanup(service.Close)
+
+	e := echo.New()
+	e.GET("/auth/login/:provider", service.Login)
+	e.GET("/auth/callback/:provider", service.Callback)
+	e.POST("/auth/callback/:provider", service.Callback)
+	e.GET("/auth/session", service.Session)
+
+	app := httptest.NewTLSServer(e)
+	t.Cleanup(app.Close)
+
+	// httptest TLS servers share a certificate, so the client trusts both.
+	client := app.Client()
+	client.Jar, _ = cookiejar.New(nil)
+	return app, client
+}
+
+// pick submits the user picker the login redirected to.
+func pick(t *testing.T, client *http.Client, picker *http.Response, form url.Values) *http.Response {
+	picker.Body.Close()
+	if picker.StatusCode != http.StatusOK || picker.Request.URL.Path != "/authorize" {
+		t.Fatalf("expected the user picker, got %d %s", picker.StatusCode, picker.Request.URL)
+	}
+
+	for key, values := range picker.Request.URL.Query() {
+		form[key] = values
+	}
+	res, err := client.PostForm(picker.Request.URL.String(), form)
+	if err != nil {
+		t.Fatalf("submitting the picker: %v", err)
+	}
+	return res
+}
+
+func TestLoginCallbackFlow(t *testing.T) {
+	_, idp := newTestIdP(t)
+	app, client := newTestApp(t, idp)
+
+	picker, err := client.Get(app.URL + "/auth/login/mock")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if challenge := picker.Request.URL.Query().Get("code_challenge"); challenge == "" {
+		t.Errorf("expected PKCE, as the discovery document advertises S256")
+	}
+
+	res := pick(t, client, picker, url.Values{"user": {"alice"}})
+	var user auth.User
+	json.NewDecoder(res.Body).Decode(&user)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || user.Email != "alice@example.com" || user.EmailVerified == nil {
+		t.Fatalf("expected to sign in as alice, got %d %+v", res.StatusCode, user)
+	}
+
+	res, err = client.Get(app.URL + "/auth/session")
+	if err != nil {
+		t.Fatalf("session: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected a session after signing in, got %d", res.StatusCode)
+	}
+}
+
+func TestLoginDenied(t *testing.T) {
+	_, idp := newTestIdP(t)
+	app, client := newTestApp(t, idp)
+
+	picker, err := client.Get(app.URL + "/auth/login/mock")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	res := pick(t, client, picker, url.Values{"deny": {"1"}})
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected access to be denied, got %d", res.StatusCode)
+	}
+}
+
+func TestToken(t *testing.T) {
+	idp, _ := newTestIdP(t)
+
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		idp.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post("/authorize", url.Values{
+		"client_id":             {"client"},
+		"redirect_uri":          {"https://app.example.com/callback"},
+		"response_type":         {"code"},
+		"user":                  {"bob"},
+		"code_challenge":        {codeChallengeS256("verifier")},
+		"code_challenge_method": {"S256"},
+	})
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	code := location.Query().Get("code")
+	if code == "" {
+		t.Fatalf("expected a code, got %d %s", rec.Code, location)
+	}
+
+	exchange := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"client"},
+		"client_secret": {"secret"},
+		"code":          {code},
+		"redirect_uri":  {"https://app.example.com/callback"},
+		"code_verifier": {"wrong"},
+	}
+	if rec := post("/token", exchange); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a wrong code_verifier to be rejected, got %d", rec.Code)
+	}
+
+	// Codes are single use, even after a failed exchange.
+	exchange.Set("code_verifier", "verifier")
+	if rec := post("/token", exchange); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a used code to be rejected, got %d", rec.Code)
+	}
+
+	exchange = url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {"client"},
+		"client_secret": {"wrong"},
+		"refresh_token": {"unknown"},
+	}
+	if rec := post("/token", exchange); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a wrong client_secret to be rejected, got %d", rec.Code)
+	}
+}
@@ -4,18 +4,50 @@ import (
 	"echo-server/internal/auth"
 	"echo-server/internal/auth/adapters"
 	"echo-server/internal/auth/providers"
+	"log"
 	"net/http"
+	"os"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
 
-var authService = auth.New(auth.AuthServiceOptions{
-	Providers: []auth.Provider{
+// newAuthService configures authentication from the environment. Providers
+// configured through discovery call out to their issuer, so this runs when
+// the server is created rather than at package initialization.
+func newAuthService() auth.Service {
+	return auth.New(auth.AuthServiceOptions{
+		Providers:  authProviders(),
+		Adapter:    adapters.SQLite("auth.db"),
+		BaseURL:    os.Getenv("AUTH_URL"),
+		TrustProxy: os.Getenv("AUTH_TRUST_PROXY") == "true",
+		// A comma separated list of client_ids, e.g. AUTH_DEVICE_CLIENTS=cli.
+		DeviceClients: strings.FieldsFunc(os.Getenv("AUTH_DEVICE_CLIENTS"), func(r rune) bool { return r == ',' }),
+	})
+}
+
+func authProviders() []auth.Provider {
+	list := []auth.Provider{
 		providers.Google(),
-	},
-	Adapter: adapters.SQLite("auth.db"),
-})
+	}
+
+	// Sign in against cmd/mock-idp during local development.
+	if issuer := os.Getenv("MOCK_IDP_ISSUER"); issuer != "" {
+		mock, err := providers.OIDC(issuer, os.Getenv("MOCK_IDP_CLIENT_ID"), os.Getenv("MOCK_IDP_CLIENT_SECRET"), providers.OIDCOptions{
+			Id:                "mock",
+			Name:              "Mock",
+			AllowEmailLinking: true,
+		})
+		if err != nil {
+			log.Printf("cannot configure the mock identity provider: %s", err)
+		} else {
+			list = append(list, mock)
+		}
+	}
+
+	return list
+}
 
 func (s *Server) RegisterRoutes() http.Handler {
 	e := echo.New()
@@ -26,10 +58,21 @@ func (s *Server) RegisterRoutes() http.Handler {
 	e.GET("/health", s.healthHandler)
 
 	authGroup := e.Group("/auth")
-	authGroup.GET("/providers", authService.Providers)
-	authGroup.GET("/login/:provider", authService.Login)
-	authGroup.GET("/callback/:provider", authService.Callback)
-	authGroup.GET("/session", authService.Session)
+	authGroup.GET("/providers", s.auth.Providers)
+	authGroup.GET("/login/:provider", s.auth.Login)
+	authGroup.GET("/callback/:provider", s.auth.Callback)
+	authGroup.POST("/callback/:provider", s.auth.Callback)
+	authGroup.GET("/error", s.auth.Error)
+	authGroup.GET("/session", s.auth.Session)
+	authGroup.POST("/signout", s.auth.SignOut)
+	authGroup.POST("/signout/all", s.auth.SignOutAll)
+	authGroup.GET("/link/:provider", s.auth.Link)
+	authGroup.GET("/accounts", s.auth.Accounts)
+	authGroup.DELETE("/accounts/:provider", s.auth.Unlink)
+	authGroup.POST("/device/code", s.auth.DeviceCode)
+	authGroup.POST("/device/token", s.auth.DeviceToken)
+	authGroup.GET("/device", s.auth.Device)
+	authGroup.POST("/device", s.auth.VerifyDevice)
 
 	return e
 }
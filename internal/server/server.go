@@ -9,21 +9,27 @@ import (
 
 	_ "github.com/joho/godotenv/autoload"
 
+	"echo-server/internal/auth"
 	"echo-server/internal/database"
 )
 
 type Server struct {
 	port int
 
-	db database.Service
+	db   database.Service
+	auth *auth.Service
 }
 
-func NewServer() *http.Server {
+// NewServer returns the HTTP server and a function that stops the background
+// work of the auth service. Call it once the server has shut down.
+func NewServer() (*http.Server, func()) {
 	port, _ := strconv.Atoi(os.Getenv("PORT"))
+	authService := newAuthService()
 	NewServer := &Server{
 		port: port,
 
 		// db: database.New(),
+		auth: &authService,
 	}
 
 	// Declare Server config
@@ -35,5 +41,5 @@ func NewServer() *http.Server {
 		WriteTimeout: 30 * time.Second,
 	}
 
-	return server
+	return server, NewServer.auth.Close
 }